@@ -30,8 +30,8 @@ package main
 import (
 	"flag"
 	"fmt"
-	"bufio"
 	"os"
+	"sort"
 )
 
 // Create some constants
@@ -77,14 +77,73 @@ var opt = map[int]option{
 	1: {"Label Data Set", interactiveLabelDataSet},
 	2: {"Build training and test set", interactiveBuildTrainAndTestSet},
 	3: {"Convert formats", interactiveConvert},
+	4: {"Show data set statistics", interactiveStats},
 }
 
-func init() {
-	Stdin = bufio.NewReader(os.Stdin)
+// A command is an operation which can be run straight from the command
+// line, without the interactive console, so that adp may be used in scripts
+// and pipelines. Commands take their options from flags alone, and read the
+// files named after them, or standard input if there are none.
+type command struct {
+	desc string
+	run  func(files []string)
+}
+
+// Here we define the commands which may be given on the command line
+var commands = map[string]command{
+	"label":   {"label data using the rules given by -r", commandLabel},
+	"split":   {"split labeled data into training and test sets", commandSplit},
+	"convert": {"convert data to the formats given by -to", commandConvert},
+}
+
+// usage describes how adp is run, along with its commands and flags
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command [flags] [files]]\n",
+		os.Args[0])
+	fmt.Fprintln(os.Stderr, "Without a command adp runs interactively. "+
+		"A file named - is standard input or output. Input compressed with "+
+		"gzip, bzip2 or xz is decompressed, xz through the xz command. "+
+		"Commands:")
+	names := make(sort.StringSlice, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	names.Sort()
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s%s\n", name, commands[name].desc)
+	}
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+// runCommand runs the command named by the first of args. Flags may be given
+// after the name of the command as well as before it.
+func runCommand(args []string) {
+	cmd, exists := commands[args[0]]
+	if !exists {
+		fmt.Fprintln(os.Stderr, "Unknown command:", args[0])
+		usage()
+		os.Exit(2)
+	}
+	os.Args = append([]string{os.Args[0]}, args[1:]...)
+	flag.Parse()
+	errCheck(checkOutputFlags())
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{stdioName}
+	}
+	errCheck(checkOutputCount(len(files), 1))
+	cmd.run(files)
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
+	errCheck(checkOutputFlags())
+	if flag.NArg() > 0 {
+		runCommand(flag.Args())
+		return
+	}
 
 	// MAIN ------------------------- 
 	displayWelcome()
@@ -100,6 +159,17 @@ func main() {
 	// ------------------------------
 }
 
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 //state 0
 func exit() {
 	fmt.Println("Exiting")
@@ -0,0 +1,562 @@
+/* 
+ * anonymize.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	anonMethodFlag = flag.String("anon-method", "cryptopan",
+		"how addresses are anonymized: cryptopan (prefix preserving), hash, "+
+			"truncate or drop")
+	anonKeyFlag = flag.String("anon-key", "anon.key",
+		"file holding the key addresses are anonymized with, created if missing")
+	anonBitsFlag = flag.Int("anon-bits", 24,
+		"bits of IPv4 addresses kept by -anon-method truncate")
+	anonBits6Flag = flag.Int("anon-bits6", 48,
+		"bits of IPv6 addresses kept by -anon-method truncate")
+	anonColumnsFlag = flag.String("anon-columns", "",
+		"comma separated list of further columns to anonymize, besides addresses")
+)
+
+// The length of an anonymization key: an AES-128 key and the block from which
+// Crypto-PAn's pad is made
+const anonKeyLen = 32
+
+// loadAnonKey reads the key held in the named file, as hex or as raw bytes.
+// If there is no such file a new random key is written to it, which should
+// be kept so that later data may be anonymized the same way.
+func loadAnonKey(fileName string) ([]byte, os.Error) {
+	if _, err := os.Stat(fileName); err != nil {
+		key := make([]byte, anonKeyLen)
+		if _, err = io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		keyFile, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		defer keyFile.Close()
+		if _, err = keyFile.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+			return nil, err
+		}
+		log.Printf("Created a new key in %s, keep it to anonymize further data "+
+			"the same way\n", fileName)
+		return key, nil
+	}
+	text, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := hex.DecodeString(strings.TrimSpace(string(text))); err == nil &&
+		len(key) >= anonKeyLen {
+		return key[0:anonKeyLen], nil
+	}
+	if len(text) < anonKeyLen {
+		return nil, os.NewError(fileName + " holds too short a key")
+	}
+	return text[0:anonKeyLen], nil
+}
+
+// cryptoPan anonymizes addresses as described by Xu et al. in "Prefix-
+// preserving IP address anonymization" (2002): two addresses sharing a
+// prefix of n bits are mapped to addresses which share a prefix of n bits.
+// Each bit of an address is flipped by the first bit of the encryption of
+// the bits before it, padded out with a secret pad.
+type cryptoPan struct {
+	block *aes.Cipher
+	pad   []byte
+}
+
+func newCryptoPan(key []byte) (*cryptoPan, os.Error) {
+	block, err := aes.NewCipher(key[0:16])
+	if err != nil {
+		return nil, err
+	}
+	p := &cryptoPan{block: block, pad: make([]byte, aes.BlockSize)}
+	block.Encrypt(p.pad, key[16:32])
+	return p, nil
+}
+
+// anonymize returns the pseudonym of the IPv4 or IPv6 address ip
+func (p *cryptoPan) anonymize(ip []byte) []byte {
+	in := make([]byte, aes.BlockSize)
+	out := make([]byte, aes.BlockSize)
+	result := make([]byte, len(ip))
+	for pos := 0; pos < len(ip)*8; pos++ {
+		// The first pos bits of the address, followed by the pad
+		copy(in, p.pad)
+		copy(in, ip[0:pos/8])
+		if bits := uint(pos % 8); bits > 0 {
+			mask := byte(0xff << (8 - bits))
+			in[pos/8] = ip[pos/8]&mask | p.pad[pos/8]&^mask
+		}
+		p.block.Encrypt(out, in)
+		result[pos/8] |= (out[0] >> 7) << uint(7-pos%8)
+	}
+	for i := range result {
+		result[i] ^= ip[i]
+	}
+	return result
+}
+
+// An anonymizer replaces values with pseudonyms, always giving the same
+// pseudonym for the same value and key.
+type anonymizer struct {
+	method string
+	pan    *cryptoPan
+	mac    hash.Hash
+	// The pseudonyms given so far
+	seen map[string]string
+}
+
+func newAnonymizer(method string) (*anonymizer, os.Error) {
+	a := &anonymizer{method: method, seen: map[string]string{}}
+	switch method {
+	case "cryptopan", "hash", "truncate":
+	case "drop":
+		// Dropped columns need no pseudonyms, so there is no need of a key
+		return a, nil
+	default:
+		return nil, os.NewError("unknown anonymization method " + method)
+	}
+	key, err := loadAnonKey(*anonKeyFlag)
+	if err != nil {
+		return nil, err
+	}
+	if a.pan, err = newCryptoPan(key); err != nil {
+		return nil, err
+	}
+	a.mac = hmac.NewSHA256(key)
+	return a, nil
+}
+
+// keyedHash returns the first n bytes of the keyed hash of b
+func (a *anonymizer) keyedHash(b []byte, n int) []byte {
+	a.mac.Reset()
+	a.mac.Write(b)
+	return a.mac.Sum()[0:n]
+}
+
+// truncate zeroes all but the first bits bits of ip
+func truncate(ip []byte, bits int) []byte {
+	result := make([]byte, len(ip))
+	for i := range ip {
+		switch {
+		case bits >= (i+1)*8:
+			result[i] = ip[i]
+		case bits > i*8:
+			result[i] = ip[i] & byte(0xff<<uint(8-(bits-i*8)))
+		}
+	}
+	return result
+}
+
+// pseudonym returns the value which replaces v. Addresses are replaced by
+// addresses, and anything else by a keyed hash. Missing values are left as
+// they are.
+func (a *anonymizer) pseudonym(v string) string {
+	if v == "" || v == "?" {
+		return v
+	}
+	if p, exists := a.seen[v]; exists {
+		return p
+	}
+	var p string
+	ip := net.ParseIP(v)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	switch {
+	case ip == nil:
+		p = hex.EncodeToString(a.keyedHash([]byte(v), 8))
+	case a.method == "hash":
+		p = net.IP(a.keyedHash(ip, len(ip))).String()
+	case a.method == "truncate" && len(ip) == net.IPv4len:
+		p = net.IP(truncate(ip, *anonBitsFlag)).String()
+	case a.method == "truncate":
+		p = net.IP(truncate(ip, *anonBits6Flag)).String()
+	default:
+		p = net.IP(a.pan.anonymize(ip)).String()
+	}
+	a.seen[v] = p
+	return p
+}
+
+// anonColumns returns which columns of the data are anonymized, given the
+// fields of its first line and a header naming its columns. These are the
+// address columns of the schema if there is one, along with any given by
+// -anon-columns. When they are to be dropped, the identifier columns of the
+// schema are dropped too. Without a schema, a nominal ARFF attribute is
+// anonymized if any of its values is an address, and the other string
+// columns are returned as watched, to be decided by watchAddresses.
+func anonColumns(s *schema, hdr *header,
+	fields []string) (cols, watched map[int]bool, err os.Error) {
+	if len(fields) > hdr.features.Len() {
+		return nil, nil, fmt.Errorf("expected %d values, found %d",
+			hdr.features.Len(), len(fields))
+	}
+	cols = map[int]bool{}
+	watched = map[int]bool{}
+	for i := range fields {
+		f := hdr.attr(i)
+		switch {
+		case s != nil:
+			cols[i] = s.Columns[i].Type == columnIP ||
+				(*anonMethodFlag == "drop" && s.Columns[i].Role == roleIdentifier)
+		case f.datatype == typeNominal:
+			for _, v := range f.nominal {
+				if net.ParseIP(v) != nil {
+					cols[i] = true
+				}
+			}
+		case f.datatype == typeString:
+			watched[i] = false
+		}
+	}
+	named, err := parseColumnList(*anonColumnsFlag, hdr)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, i := range named {
+		cols[i] = true
+		watched[i] = false, false
+	}
+	return cols, watched, nil
+}
+
+// watchAddresses decides which of the watched columns hold addresses, given
+// the fields of a line. A column becomes an address column if the first of
+// its values which isn't missing is an address, and is otherwise set in
+// watched. An address found later in a column set in watched is an error,
+// rather than being let through. When columns can no longer be added, as when
+// they are dropped and the header has been written, finding an address
+// column is an error too.
+func watchAddresses(watched, cols map[int]bool, fields []string, hdr *header,
+	final bool) os.Error {
+	for i, seen := range watched {
+		if i >= len(fields) {
+			continue
+		}
+		v, quoted, err := unquote(fields[i])
+		if err != nil {
+			return err
+		}
+		if (v == "" || v == "?") && !quoted {
+			continue
+		}
+		name := hdr.attr(i).name
+		switch {
+		case net.ParseIP(v) == nil:
+			watched[i] = true
+		case seen:
+			return os.NewError("column " + name + " holds the address " + v +
+				" among other values, so it is not anonymized; name it in " +
+				"-anon-columns or describe it by -schema")
+		case final:
+			return os.NewError("column " + name + " holds addresses, but " +
+				"none on the first line; name it in -anon-columns or " +
+				"describe it by -schema")
+		default:
+			cols[i] = true
+			watched[i] = false, false
+		}
+	}
+	return nil
+}
+
+// anonFeature returns the attribute holding the anonymized values of f.
+// Nominal values are replaced by their pseudonyms, and numbers and dates
+// become strings, as they are replaced by keyed hashes.
+func (a *anonymizer) anonFeature(f *feature) *feature {
+	anon := *f
+	switch f.datatype {
+	case typeNominal:
+		anon.nominal = make([]string, len(f.nominal))
+		for j, v := range f.nominal {
+			anon.nominal[j] = a.pseudonym(v)
+		}
+	case typeString:
+	default:
+		anon = feature{name: f.name, datatype: typeString}
+	}
+	return &anon
+}
+
+// anonSchemaColumn returns the column of a schema describing the anonymized
+// values of c, and whether it differs from c. Addresses are replaced by
+// addresses, but anything else by a pseudonym or a keyed hash.
+func (a *anonymizer) anonSchemaColumn(c schemaColumn) (schemaColumn, bool) {
+	switch c.Type {
+	case columnIP, columnString:
+		return c, false
+	case columnNominal, columnLabel:
+		if len(c.Values) == 0 {
+			return c, false
+		}
+		values := make([]string, len(c.Values))
+		for j, v := range c.Values {
+			values[j] = a.pseudonym(v)
+		}
+		c.Values = values
+		return c, true
+	}
+	c.Type, c.Format = columnString, ""
+	return c, true
+}
+
+// numberedHeader returns a header naming n columns attr0, attr1 and so on, or
+// by the given names, as inferCsvHeader does. The columns are taken to be
+// strings, until more is known of them.
+func numberedHeader(names []string, n int) *header {
+	hdr := new(header)
+	for i := 0; i < n; i++ {
+		f := &feature{name: "attr" + strconv.Itoa(i), datatype: typeString}
+		if names != nil && i < len(names) {
+			f.name = names[i]
+		}
+		hdr.features.Push(f)
+	}
+	return hdr
+}
+
+// anonymizeFile writes a copy of the named CSV or ARFF file with its
+// addresses, and any other columns given by -anon-columns, anonymized. The
+// same key anonymizes the same value the same way in every file, so that
+// training and test sets stay consistent. The copy of an ARFF file is named
+// .anon.arff, so that it is still read as ARFF. When reading standard input
+// the copy is written to standard output. Sparse ARFF data is not supported.
+func anonymizeFile(fileName string, a *anonymizer) os.Error {
+	debugMsg("Opening file: %s", fileName)
+	dataFile, err := openInput(fileName)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	dataReader := bufio.NewReader(dataFile)
+	start, _ := dataReader.Peek(1)
+	arff := len(start) > 0 && (start[0] == '@' || start[0] == '%')
+	suffix := ".anon"
+	if arff {
+		suffix = ".anon.arff"
+	}
+	names := newOutputNames(fileName, suffix)
+	outName, err := names.name(suffix)
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(outName)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	var hdr *header
+	lineCount := 0
+	if arff {
+		h, lines, err := readHeader(dataReader)
+		if err != nil {
+			return err
+		}
+		hdr, lineCount = &h, lines
+	}
+	var s *schema
+	var cols, watched map[int]bool
+	var headerRow []string
+	for {
+		line, err := dataReader.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			if err != os.EOF {
+				return err
+			}
+			break
+		}
+		lineCount++
+		line = strings.TrimSpace(line)
+		if line == "" || (arff && line[0] == '%') {
+			continue
+		}
+		if arff && line[0] == '{' {
+			return &parseError{lineCount, "sparse ARFF data cannot be anonymized"}
+		}
+		fields, err := splitFields(line, ',')
+		if err != nil {
+			return &parseError{lineCount, err.String()}
+		}
+		if !arff && csvOpts.headerRow && headerRow == nil {
+			headerRow = fields
+			continue
+		}
+		if cols == nil {
+			if !arff {
+				if s = detectSchema(fields, false); s == nil && *schemaFlag == "" {
+					s = detectSchema(fields, true)
+				}
+				if s != nil {
+					hdr = &s.hdr
+				} else {
+					hdr = numberedHeader(headerRow, len(fields))
+				}
+			} else if s = datasetSchema(); s != nil {
+				if err = s.checkHeader(hdr); err != nil {
+					return err
+				}
+			}
+			if cols, watched, err = anonColumns(s, hdr, fields); err != nil {
+				return err
+			}
+			if err = watchAddresses(watched, cols, fields, hdr, false); err != nil {
+				return &parseError{lineCount, err.String()}
+			}
+			if err = writeAnonHeader(out.Writer, a, hdr, headerRow, cols, arff); err != nil {
+				return err
+			}
+			if err = saveAnonSchema(s, a, cols, names); err != nil {
+				return err
+			}
+		} else {
+			err = watchAddresses(watched, cols, fields, hdr, a.method == "drop")
+			if err != nil {
+				return &parseError{lineCount, err.String()}
+			}
+		}
+		var kept []string
+		for i, field := range fields {
+			switch {
+			case !cols[i]:
+				kept = append(kept, field)
+			case a.method != "drop":
+				v, _, err := unquote(field)
+				if err != nil {
+					return &parseError{lineCount, err.String()}
+				}
+				kept = append(kept, arffQuote(a.pseudonym(v)))
+			}
+		}
+		if _, err = out.WriteString(strings.Join(kept, ",") + "\n"); err != nil {
+			return err
+		}
+	}
+	if arff && cols == nil {
+		// There is no data, so nothing is anonymized
+		if err = writeArffHeader(hdr, out.Writer); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// writeAnonHeader writes the header of the anonymized copy: for ARFF the
+// header with the dropped attributes left out and the anonymized attributes
+// given by anonFeature, and for CSV any header row.
+func writeAnonHeader(out *bufio.Writer, a *anonymizer, hdr *header,
+	headerRow []string, cols map[int]bool, arff bool) os.Error {
+	dropped := a.method == "drop"
+	if !arff {
+		if headerRow == nil {
+			return nil
+		}
+		var kept []string
+		for i, name := range headerRow {
+			if !dropped || !cols[i] {
+				kept = append(kept, name)
+			}
+		}
+		_, err := out.WriteString(strings.Join(kept, ",") + "\n")
+		return err
+	}
+	anon := header{name: hdr.name}
+	for i := 0; i < hdr.features.Len(); i++ {
+		switch {
+		case !cols[i]:
+			anon.features.Push(hdr.attr(i))
+		case !dropped:
+			anon.features.Push(a.anonFeature(hdr.attr(i)))
+		}
+	}
+	return writeArffHeader(&anon, out)
+}
+
+// saveAnonSchema writes the schema of an anonymized copy of CSV data to an
+// .anon.columns.json file, when columns described by the schema s were
+// dropped or no longer hold the values it describes
+func saveAnonSchema(s *schema, a *anonymizer, cols map[int]bool,
+	names *outputNames) os.Error {
+	if s == nil {
+		return nil
+	}
+	changed := a.method == "drop"
+	anon := &schema{Relation: s.Relation}
+	for i, c := range s.Columns {
+		switch {
+		case !cols[i]:
+			anon.Columns = append(anon.Columns, c)
+		case a.method != "drop":
+			c, differs := a.anonSchemaColumn(c)
+			anon.Columns = append(anon.Columns, c)
+			changed = changed || differs
+		}
+	}
+	if !changed {
+		return nil
+	}
+	schemaName, err := names.name(".anon.columns.json")
+	if err != nil {
+		return err
+	}
+	return saveSchema(anon, schemaName)
+}
+
+// commandAnonymize anonymizes each of the files with the method given by
+// -anon-method
+func commandAnonymize(files []string) {
+	a, err := newAnonymizer(*anonMethodFlag)
+	errCheck(err)
+	for _, fileName := range files {
+		errCheck(anonymizeFile(fileName, a))
+	}
+}
+
+func init() {
+	commands["anonymize"] = command{
+		"anonymize the addresses of data before it is shared", commandAnonymize}
+}
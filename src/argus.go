@@ -0,0 +1,161 @@
+/* 
+ * argus.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The columns of Argus records, as named by ra, which have an equivalent in
+// Zeek's conn.log are given its names, so that the same rules may label both.
+// Others keep their Argus names, in lower case.
+var argusColumns = map[string]schemaColumn{
+	"StartTime": {Name: "ts", Type: columnFloat, Role: roleTimestamp},
+	"LastTime":  {Name: "lasttime", Type: columnFloat, Role: roleFeature},
+	"Dur":       {Name: "duration", Type: columnFloat, Role: roleFeature},
+	"Proto":     {Name: "proto", Type: columnNominal, Role: roleFeature},
+	"SrcAddr":   {Name: "id.orig_h", Type: columnIP, Role: roleIdentifier},
+	"Sport":     {Name: "id.orig_p", Type: columnPort, Role: roleFeature},
+	"DstAddr":   {Name: "id.resp_h", Type: columnIP, Role: roleIdentifier},
+	"Dport":     {Name: "id.resp_p", Type: columnPort, Role: roleFeature},
+	"SrcPkts":   {Name: "orig_pkts", Type: columnInt, Role: roleFeature},
+	"DstPkts":   {Name: "resp_pkts", Type: columnInt, Role: roleFeature},
+	"SrcBytes":  {Name: "orig_ip_bytes", Type: columnInt, Role: roleFeature},
+	"DstBytes":  {Name: "resp_ip_bytes", Type: columnInt, Role: roleFeature},
+	"Flgs":      {Name: "flgs", Type: columnNominal, Role: roleFeature},
+	"Dir":       {Name: "dir", Type: columnNominal, Role: roleFeature},
+	"State":     {Name: "state", Type: columnNominal, Role: roleFeature},
+	"TotPkts":   {Name: "totpkts", Type: columnInt, Role: roleFeature},
+	"TotBytes":  {Name: "totbytes", Type: columnInt, Role: roleFeature},
+	"sTos":      {Name: "stos", Type: columnInt, Role: roleFeature},
+	"dTos":      {Name: "dtos", Type: columnInt, Role: roleFeature},
+	"sTtl":      {Name: "sttl", Type: columnInt, Role: roleFeature},
+	"dTtl":      {Name: "dttl", Type: columnInt, Role: roleFeature},
+}
+
+// An argusReader reads the CSV records written by Argus' ra -c , which start
+// with a row naming the columns.
+type argusReader struct {
+	in   *bufio.Reader
+	line int // The number of the line last read
+	// The names ra gave the columns
+	fields []string
+	cols   []schemaColumn
+}
+
+func newArgusReader(in *bufio.Reader) *argusReader {
+	return &argusReader{in: in}
+}
+
+func (r *argusReader) columns() []schemaColumn {
+	return r.cols
+}
+
+func (r *argusReader) next() ([]string, os.Error) {
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			return nil, err
+		}
+		r.line++
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		values := strings.Split(line, ",")
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+		if r.fields == nil {
+			r.fields = values
+			continue
+		}
+		if len(values) != len(r.fields) {
+			return nil, &parseError{r.line, strconv.Itoa(len(r.fields)) +
+				" fields expected, " + strconv.Itoa(len(values)) + " found"}
+		}
+		if r.cols == nil {
+			r.cols = argusSchema(r.fields, values)
+		}
+		for i, v := range values {
+			switch {
+			case v == "":
+				values[i] = "?"
+			case r.cols[i].Type == columnPort && strings.HasPrefix(v, "0x"):
+				// ICMP types and codes are written in hex
+				if n, err := strconv.Btoui64(v[2:], 16); err == nil {
+					values[i] = strconv.Itoa64(int64(n))
+				}
+			}
+		}
+		return values, nil
+	}
+	panic("unreachable")
+}
+
+// argusSchema returns the columns named by ra, given the first record. The
+// type of a column ra names which isn't known is guessed from that record,
+// as a number if it is one and as a nominal otherwise. Times are only numbers
+// when ra writes them as seconds since the epoch (ra -u), and are kept as
+// strings otherwise.
+func argusSchema(fields, first []string) []schemaColumn {
+	cols := make([]schemaColumn, len(fields))
+	for i, name := range fields {
+		_, err := strconv.Atof64(first[i])
+		c, known := argusColumns[name]
+		switch {
+		case !known:
+			c = schemaColumn{Name: strings.ToLower(name), Type: columnNominal,
+				Role: roleFeature}
+			if err == nil {
+				c.Type = columnFloat
+			}
+		case c.Type == columnFloat && err != nil && first[i] != "":
+			c.Type = columnString
+		}
+		cols[i] = c
+	}
+	return cols
+}
+
+// commandArgus reads each of the Argus CSV files
+func commandArgus(files []string) {
+	for _, fileName := range files {
+		errCheck(importLog(fileName, func(in *bufio.Reader) logReader {
+			return newArgusReader(in)
+		}))
+	}
+}
+
+func init() {
+	commands["argus"] = command{"read Argus records written by ra -c ,",
+		commandArgus}
+}
@@ -0,0 +1,293 @@
+/* 
+ * c50.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// cFiveEscape escapes the characters which have a special meaning to C5.0
+// when they appear in names or values. Periods only need to be escaped in the
+// .names file, where they end each declaration.
+func cFiveEscape(s string, period bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', ',', ':', '|', '?':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '.':
+			if period {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(c)
+		case '\n', '\r', '\t':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// writeNamesCFive writes a C5.0 .names file describing hdr. The feature with
+// index class is declared as the target, and any features in ignore are
+// declared as ignored.
+func writeNamesCFive(hdr *header, class int, ignore map[int]bool,
+	namesfile io.Writer) os.Error {
+	if hdr.attr(class).datatype != typeNominal {
+		return os.NewError("C5.0 class attribute " + hdr.attr(class).name +
+			" must be nominal")
+	}
+	out := bufio.NewWriter(namesfile)
+	fmt.Fprintf(out, "| Generated by adp from relation %s\n\n", hdr.name)
+	fmt.Fprintf(out, "%s.\n\n", cFiveEscape(hdr.attr(class).name, true))
+	for i := 0; i < hdr.features.Len(); i++ {
+		f := hdr.attr(i)
+		var decl string
+		switch {
+		case ignore[i] && i != class:
+			decl = "ignore"
+		case f.isNumeric():
+			decl = "continuous"
+		case f.datatype == typeNominal:
+			values := make([]string, len(f.nominal))
+			for j, v := range f.nominal {
+				values[j] = cFiveEscape(v, true)
+			}
+			decl = strings.Join(values, ", ")
+		case f.datatype == typeDate:
+			decl = "timestamp"
+		default:
+			// C5.0 has no use for free text, so leave it out of the model
+			decl = "ignore"
+		}
+		fmt.Fprintf(out, "%s: %s.\n", cFiveEscape(f.name, true), decl)
+	}
+	return out.Flush()
+}
+
+// writelineCFive writes the given instance as a line of a C5.0 .data or .test
+// file. Missing values are written as C5.0's unknown value.
+func writelineCFive(inst *instance, hdr *header, out *bufio.Writer) os.Error {
+	for i, v := range inst.values {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		switch {
+		case v.missing:
+			out.WriteString("?")
+		case hdr.attr(i).datatype == typeDate:
+			out.WriteString(time.SecondsToUTC(int64(v.num)).Format(
+				"2006/01/02 15:04:05"))
+		default:
+			out.WriteString(cFiveEscape(v.str, false))
+		}
+	}
+	_, err := out.WriteString("\n")
+	return err
+}
+
+// checkSameHeader makes sure two headers declare the same features, so that
+// a test set may be written against the .names file of a training set. Any
+// nominal values of the test set must also be known to the training set.
+func checkSameHeader(train, test *header) os.Error {
+	if train.features.Len() != test.features.Len() {
+		return fmt.Errorf("headers declare %d and %d attributes",
+			train.features.Len(), test.features.Len())
+	}
+	for i := 0; i < train.features.Len(); i++ {
+		a, b := train.attr(i), test.attr(i)
+		if a.name != b.name || a.datatype != b.datatype {
+			return fmt.Errorf("attribute %d differs (%s and %s)", i,
+				a.name, b.name)
+		}
+		for _, v := range b.nominal {
+			if a.nominalIndex(v) < 0 {
+				return fmt.Errorf("value %s of attribute %s is not in the "+
+					"training set", v, a.name)
+			}
+		}
+	}
+	return nil
+}
+
+var cFiveTestFlag = flag.String("c50-test", "",
+	"data file to convert into the C5.0 .test file")
+
+// cFiveConverter writes the .names and .data files C5.0 reads, along with a
+// .test file if test data is given. Excluded attributes are declared as
+// ignored, since C5.0 expects every attribute to appear in the data. The
+// files share the name of the data file as their stem, as C5.0 expects.
+type cFiveConverter struct {
+	hdr   *header
+	class int
+	// The name of the .test file, if there is test data
+	testName string
+	out      *output
+	// The number of instances left out for want of a label
+	skipped int
+}
+
+func (c *cFiveConverter) open(hdr *header, names *outputNames) os.Error {
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	ignore, err := excludedColumns(hdr)
+	if err != nil {
+		return err
+	}
+	namesName, err := cFiveName(names, ".names")
+	if err != nil {
+		return err
+	}
+	dataName, err := cFiveName(names, ".data")
+	if err != nil {
+		return err
+	}
+	if *cFiveTestFlag != "" {
+		if c.testName, err = cFiveName(names, ".test"); err != nil {
+			return err
+		}
+	}
+	namesFile, err := createFile(namesName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(namesFile)
+	if err = writeNamesCFive(hdr, class, ignore, namesFile); err != nil {
+		return err
+	}
+	if c.out, err = createOutput(dataName); err != nil {
+		return err
+	}
+	c.hdr, c.class = hdr, class
+	return nil
+}
+
+// cFiveName returns the name of the C5.0 file with the given suffix. The
+// files share the stem of the .data file, so that -o renames all of them.
+func cFiveName(names *outputNames, suffix string) (string, os.Error) {
+	data, err := names.name(".data")
+	if err != nil || suffix == ".data" || data != *outputFlag ||
+		data == stdioName {
+		return names.name(suffix)
+	}
+	if strings.HasSuffix(data, ".data") {
+		data = data[0 : len(data)-len(".data")]
+	}
+	return data + suffix, nil
+}
+
+// write writes the instance to the .data file, leaving it out if it has no
+// class label.
+func (c *cFiveConverter) write(inst *instance) os.Error {
+	if inst.values[c.class].missing {
+		c.skipped++
+		return nil
+	}
+	return writelineCFive(inst, c.hdr, c.out.Writer)
+}
+
+func (c *cFiveConverter) close() os.Error {
+	if err := c.out.Close(); err != nil {
+		return err
+	}
+	if c.skipped > 0 {
+		log.Printf("Skipped %d instances with no class label\n", c.skipped)
+	}
+	if *cFiveTestFlag == "" {
+		return nil
+	}
+	return c.writeTest(*cFiveTestFlag)
+}
+
+// writeTest converts the named test data into the .test file
+func (c *cFiveConverter) writeTest(testFileName string) os.Error {
+	reader, testFile, err := openConvertReader(testFileName)
+	if err != nil {
+		return err
+	}
+	defer testFile.Close()
+	if err = checkSameHeader(c.hdr, reader.header()); err != nil {
+		return err
+	}
+	outFile, err := createOutput(c.testName)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	test := &cFiveConverter{hdr: c.hdr, class: c.class, out: outFile}
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = test.write(inst); err != nil {
+			return err
+		}
+	}
+	if test.skipped > 0 {
+		log.Printf("Skipped %d test instances with no class label\n",
+			test.skipped)
+	}
+	return test.out.Close()
+}
+
+func init() {
+	registerFormat(&format{
+		name:   "c50",
+		desc:   "C5.0 / See5 (.names, .data, .test)",
+		suffix: ".data",
+		newConverter: func() converter {
+			return new(cFiveConverter)
+		},
+		configure: func() {
+			if !flagSet("c50-test") {
+				*cFiveTestFlag = promptString("test file",
+					"Please enter the path of the C5.0 test data "+
+						"(\"none\" to skip)")
+				if *cFiveTestFlag == "none" {
+					*cFiveTestFlag = ""
+				}
+			}
+		},
+	})
+}
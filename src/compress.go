@@ -0,0 +1,269 @@
+/* 
+ * compress.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"exec"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+var compressFlag = flag.String("compress", "none",
+	"compression of output data files: none, gzip, bzip2 or xz, the last "+
+		"two of which need the bzip2 and xz commands")
+
+// A codec describes one of the compression formats we understand
+type codec struct {
+	name  string
+	ext   string
+	magic string
+}
+
+var codecs = []codec{
+	{"gzip", ".gz", "\x1f\x8b"},
+	{"bzip2", ".bz2", "BZh"},
+	{"xz", ".xz", "\xfd7zXZ\x00"},
+}
+
+// findCodec returns the codec with the given name, or nil for none
+func findCodec(name string) (*codec, os.Error) {
+	if name == "none" || name == "" {
+		return nil, nil
+	}
+	for i := range codecs {
+		if codecs[i].name == name {
+			return &codecs[i], nil
+		}
+	}
+	return nil, os.NewError("unknown compression: " + name)
+}
+
+// external reports whether data is compressed with c, or decompressed if
+// decompress is set, through the command of the same name rather than the
+// standard library.
+func (c *codec) external(decompress bool) bool {
+	return c.name == "xz" || (c.name == "bzip2" && !decompress)
+}
+
+// findCommand checks that the command compressing or decompressing with c can
+// be found, if one is needed, so that a missing command is reported before
+// anything is written rather than part way through.
+func (c *codec) findCommand(decompress bool) os.Error {
+	if !c.external(decompress) {
+		return nil
+	}
+	if _, err := exec.LookPath(c.name); err != nil {
+		return os.NewError("the " + c.name + " command is needed for " +
+			c.name + " compression: " + err.String())
+	}
+	return nil
+}
+
+// checkCompressFlag checks that -compress names a codec which can be written
+func checkCompressFlag() os.Error {
+	c, err := findCodec(*compressFlag)
+	if c == nil || err != nil {
+		return err
+	}
+	return c.findCommand(false)
+}
+
+// stripCompression removes the extension of any compression format from the
+// end of fileName, so that output files may be named after the data rather
+// than after the compressed file holding it.
+func stripCompression(fileName string) string {
+	for _, c := range codecs {
+		if strings.HasSuffix(fileName, c.ext) {
+			return fileName[0 : len(fileName)-len(c.ext)]
+		}
+	}
+	return fileName
+}
+
+// An input is a file being read, which may be decompressed on the way
+type input struct {
+	file *os.File
+	r    io.Reader
+	cmd  *exec.Cmd
+}
+
+func (in *input) Read(p []byte) (int, os.Error) {
+	return in.r.Read(p)
+}
+
+func (in *input) Close() os.Error {
+	if in.cmd != nil {
+		in.cmd.Process.Kill()
+		in.cmd.Wait()
+	}
+	if in.file == os.Stdin {
+		return nil
+	}
+	return in.file.Close()
+}
+
+// openInput opens the named file for reading. Files compressed with gzip,
+// bzip2 or xz are recognised by their magic bytes, or failing that their
+// extension, and decompressed as they are read. There is no xz decoder in
+// the standard library, so xz files are read through the xz command. The
+// name "-" reads standard input, which may be compressed too.
+func openInput(fileName string) (io.ReadCloser, os.Error) {
+	file := os.Stdin
+	var err os.Error
+	if fileName != stdioName {
+		if file, err = os.Open(fileName); err != nil {
+			return nil, err
+		}
+	}
+	buffered := bufio.NewReader(file)
+	in := &input{file: file, r: buffered}
+	magic, _ := buffered.Peek(6)
+	var found *codec
+	for i, c := range codecs {
+		if bytes.HasPrefix(magic, []byte(c.magic)) ||
+			(len(magic) == 0 && strings.HasSuffix(fileName, c.ext)) {
+			found = &codecs[i]
+			break
+		}
+	}
+	if found == nil {
+		return in, nil
+	}
+	debugMsg("Decompressing %s input", found.name)
+	if err = found.findCommand(true); err != nil {
+		if file != os.Stdin {
+			file.Close()
+		}
+		return nil, err
+	}
+	switch found.name {
+	case "gzip":
+		in.r, err = gzip.NewReader(buffered)
+	case "bzip2":
+		in.r = bzip2.NewReader(buffered)
+	case "xz":
+		in.cmd = exec.Command("xz", "-dc")
+		in.cmd.Stdin = buffered
+		if in.r, err = in.cmd.StdoutPipe(); err == nil {
+			err = in.cmd.Start()
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// An output is a file being written, which may be compressed on the way. It
+// buffers what is written, and must be closed to flush everything to disk.
+type output struct {
+	*bufio.Writer
+	name string
+	file *os.File
+	// The compressor, if there is one
+	compressor io.WriteCloser
+	cmd        *exec.Cmd
+	closed     bool
+}
+
+// createOutput creates the named file for writing, truncating it if it
+// exists. If -compress names a codec, the file is compressed as it is written
+// and the extension of the codec is added to its name. As there are no bzip2
+// or xz encoders in the standard library, these are written through the
+// bzip2 and xz commands. The name "-" writes to standard output.
+func createOutput(fileName string) (*output, os.Error) {
+	c, err := findCodec(*compressFlag)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil && fileName != stdioName {
+		fileName += c.ext
+	}
+	file, err := createFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	out := &output{name: fileName, file: file}
+	var w io.Writer = file
+	if c != nil {
+		switch c.name {
+		case "gzip":
+			out.compressor, err = gzip.NewWriter(file)
+		default:
+			out.cmd = exec.Command(c.name, "-c")
+			out.cmd.Stdout = file
+			if out.compressor, err = out.cmd.StdinPipe(); err == nil {
+				err = out.cmd.Start()
+			}
+		}
+		if err != nil {
+			if file != os.Stdout {
+				file.Close()
+			}
+			return nil, err
+		}
+		w = out.compressor
+	}
+	out.Writer = bufio.NewWriter(w)
+	return out, nil
+}
+
+// Close flushes anything still buffered, finishes any compression and closes
+// the file. Standard output is flushed but left open. Closing an output more
+// than once does nothing.
+func (out *output) Close() os.Error {
+	if out.closed {
+		return nil
+	}
+	out.closed = true
+	err := out.Flush()
+	if out.compressor != nil {
+		if cerr := out.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if out.cmd != nil {
+		if werr := out.cmd.Wait(); err == nil {
+			err = werr
+		}
+	}
+	if out.file == os.Stdout {
+		return err
+	}
+	if ferr := out.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
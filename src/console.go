@@ -54,6 +54,11 @@ func Scanf(format string, v ...interface{}) (int, os.Error) {
 	var n int
 	var err os.Error
 
+	// Only start buffering standard input once the user is asked for
+	// something, as commands may be reading data from it instead.
+	if Stdin == nil {
+		Stdin = bufio.NewReader(os.Stdin)
+	}
 	for n, err = fmt.Fscanf(Stdin, format, v...); n < 1; {
 		n, err = fmt.Fscanf(Stdin, format, v...)
 	}
@@ -29,97 +29,1627 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	vector "container/vector"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
 	"log"
+	"math"
 	"os"
-	"exp/regexp"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Attribute types which may be declared in an ARFF header
+const (
+	typeNumeric = "numeric"
+	typeInteger = "integer"
+	typeReal    = "real"
+	typeNominal = "nominal"
+	typeString  = "string"
+	typeDate    = "date"
+)
+
+// The date format assumed by Weka when none is given in the header
+const defaultDateFormat = "yyyy-MM-dd'T'HH:mm:ss"
+
+// A feature describes a single attribute (column) of a data set as declared
+// by an @attribute line.
 type feature struct {
 	name     string
 	datatype string
+	// The allowed values of a nominal attribute, in declaration order
+	nominal []string
+	// The SimpleDateFormat pattern of a date attribute, and the equivalent
+	// layout for the time package
+	format string
+	layout string
+}
+
+// isNumeric reports whether the feature holds numeric, integer or real values
+func (f *feature) isNumeric() bool {
+	return f.datatype == typeNumeric || f.datatype == typeInteger ||
+		f.datatype == typeReal
+}
+
+// nominalIndex returns the position of value in the nominal specification of
+// the feature, or -1 if it is not one of the allowed values.
+func (f *feature) nominalIndex(value string) int {
+	for i, v := range f.nominal {
+		if v == value {
+			return i
+		}
+	}
+	return -1
 }
 
 type header struct {
 	name     string
 	features vector.Vector
+	// The schema describing the data, if there is one
+	schema *schema
+	// When only some of the columns of the data are read, the header of all
+	// of them and the projection choosing those which are
+	whole *header
+	kept  *projection
+}
+
+// attr returns the i'th feature declared in the header
+func (hdr *header) attr(i int) *feature {
+	return hdr.features.At(i).(*feature)
 }
 
-var attrRE *regexp.Regexp = regexp.MustCompile("^@attribute\\s+(\\w+)\\s+(\\w+)")
+// attrIndex returns the index of the feature with the given name, or -1 if
+// there is no such feature.
+func (hdr *header) attrIndex(name string) int {
+	for i := 0; i < hdr.features.Len(); i++ {
+		if hdr.attr(i).name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseColumnList parses a comma separated list of features, each given either
+// by its index or its name, returning the indexes of the features.
+func parseColumnList(list string, hdr *header) ([]int, os.Error) {
+	var cols []int
+	for _, col := range strings.Split(list, ",") {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			continue
+		}
+		i, err := strconv.Atoi(col)
+		if err != nil {
+			i = hdr.attrIndex(col)
+		}
+		if i < 0 || i >= hdr.features.Len() {
+			return nil, os.NewError("no such attribute: " + col)
+		}
+		cols = append(cols, i)
+	}
+	return cols, nil
+}
+
+// A parseError describes a problem found in an input file, along with the
+// line on which it was found.
+type parseError struct {
+	line int
+	msg  string
+}
+
+func (e *parseError) String() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// arffToken splits the next token off the front of s, returning the token and
+// whatever remains of s. Tokens are separated by whitespace and commas, the
+// braces around nominal specifications are returned as tokens of their own,
+// and a % starts a comment which runs to the end of the line. Quoted tokens
+// are returned with their quotes and escapes removed, with quoted set. An
+// empty, unquoted token means the end of the line has been reached.
+func arffToken(s string) (tok string, quoted bool, rest string, err os.Error) {
+	s = strings.TrimLeft(s, " \t\r\n,")
+	if len(s) == 0 {
+		return "", false, "", nil
+	}
+	switch q := s[0]; q {
+	case '%':
+		return "", false, "", nil
+	case '{', '}':
+		return s[0:1], false, s[1:], nil
+	case '\'', '"':
+		var buf bytes.Buffer
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					buf.WriteByte(unescapeChar(s[i]))
+				}
+			case q:
+				return buf.String(), true, s[i+1:], nil
+			default:
+				buf.WriteByte(s[i])
+			}
+		}
+		return "", false, "", os.NewError("unterminated quoted string")
+	}
+	end := strings.IndexAny(s, " \t\r\n,{}%")
+	if end < 0 {
+		end = len(s)
+	}
+	return s[0:end], false, s[end:], nil
+}
+
+// unescapeChar returns the character represented by the escape sequence \c
+func unescapeChar(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	}
+	return c
+}
+
+// parseAttribute parses the remainder of an @attribute line, following the
+// keyword itself.
+func parseAttribute(s string) (*feature, os.Error) {
+	name, _, s, err := arffToken(s)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, os.NewError("missing attribute name")
+	}
+	f := &feature{name: name}
+	tok, _, s, err := arffToken(s)
+	if err != nil {
+		return nil, err
+	}
+	switch datatype := strings.ToLower(tok); datatype {
+	case "{":
+		// Nominal specification, read values up to the closing brace
+		f.datatype = typeNominal
+		for {
+			var quoted bool
+			tok, quoted, s, err = arffToken(s)
+			if err != nil {
+				return nil, err
+			}
+			if tok == "}" && !quoted {
+				break
+			}
+			if tok == "" && !quoted {
+				return nil, os.NewError("unterminated nominal specification " +
+					"for attribute " + name)
+			}
+			if f.nominalIndex(tok) >= 0 {
+				return nil, os.NewError("duplicate nominal value " + tok +
+					" for attribute " + name)
+			}
+			f.nominal = append(f.nominal, tok)
+		}
+	case typeNumeric, typeInteger, typeReal, typeString:
+		f.datatype = datatype
+	case typeDate:
+		f.datatype = typeDate
+		f.format, _, s, err = arffToken(s)
+		if err != nil {
+			return nil, err
+		}
+		if f.format == "" {
+			f.format = defaultDateFormat
+		}
+		f.layout = goDateLayout(f.format)
+	case "relational":
+		return nil, os.NewError("relational attributes are not supported")
+	case "":
+		return nil, os.NewError("missing type for attribute " + name)
+	default:
+		return nil, os.NewError("unknown type " + tok + " for attribute " + name)
+	}
+	if tok, _, _, err = arffToken(s); err == nil && tok != "" {
+		err = os.NewError("unexpected " + tok + " after attribute " + name)
+	}
+	return f, err
+}
 
-var relRE *regexp.Regexp = regexp.MustCompile("^@relation\\s+(\\S+)")
+// readHeader parses the header of an ARFF file, stopping after the @data line.
+// It returns the header along with the number of lines which were read, so
+// that the caller may continue counting lines through the data section.
+func readHeader(infile *bufio.Reader) (hdr header, lines int, err os.Error) {
+	for {
+		line, rerr := infile.ReadString('\n')
+		if rerr != nil && (rerr != os.EOF || line == "") {
+			if rerr == os.EOF {
+				return hdr, lines, &parseError{lines, "missing @data section"}
+			}
+			return hdr, lines, rerr
+		}
+		lines++
+		keyword, _, rest, err := arffToken(line)
+		if err != nil {
+			return hdr, lines, &parseError{lines, err.String()}
+		}
+		switch strings.ToLower(keyword) {
+		case "":
+			// Blank line or comment
+		case "@relation":
+			name, _, _, err := arffToken(rest)
+			if err != nil {
+				return hdr, lines, &parseError{lines, err.String()}
+			}
+			if name == "" {
+				return hdr, lines, &parseError{lines, "missing relation name"}
+			}
+			if hdr.name != "" {
+				return hdr, lines, &parseError{lines, fmt.Sprintf(
+					"double declaration of relation name (first: %s, second: %s)",
+					hdr.name, name)}
+			}
+			hdr.name = name
+		case "@attribute":
+			f, err := parseAttribute(rest)
+			if err != nil {
+				return hdr, lines, &parseError{lines, err.String()}
+			}
+			if hdr.attrIndex(f.name) >= 0 {
+				return hdr, lines, &parseError{lines,
+					"duplicate attribute name " + f.name}
+			}
+			hdr.features.Push(f)
+		case "@data":
+			log.Printf("Read in %d attributes\n", hdr.features.Len())
+			return hdr, lines, nil
+		default:
+			return hdr, lines, &parseError{lines, "unexpected " + keyword}
+		}
+	}
+	panic("unreachable")
+}
 
-// For now just skips the header
-func readHeader(infile *bufio.Reader) header {
-	var hdr header
-	for line, err := infile.ReadString('\n'); err == nil && !strings.HasPrefix(strings.ToLower(line), "@data"); line, err = infile.ReadString('\n') {
-		line = strings.ToLower(line)
-		match := attrRE.FindStringSubmatch(line)
-		if match != nil {
-			//This line is defining an attribute
-			if len(match) < 3 {
-				log.Fatalf("Invalid header on line:\n%s", line)
+// goDateLayout translates a Java SimpleDateFormat pattern, as used by ARFF
+// date attributes, into a layout understood by time.Parse. Only the pattern
+// letters which Weka commonly writes are supported.
+func goDateLayout(format string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(format); {
+		c := format[i]
+		n := 1
+		for i+n < len(format) && format[i+n] == c {
+			n++
+		}
+		switch {
+		case c == '\'' && n >= 2:
+			// '' is a single quote, outside of quoted text as well as in it
+			buf.WriteByte('\'')
+			i += 2
+			continue
+		case c == '\'':
+			// Quoted literal text, running up to the next lone quote
+			for i++; i < len(format); i++ {
+				if format[i] == '\'' {
+					if i+1 >= len(format) || format[i+1] != '\'' {
+						break
+					}
+					i++
+				}
+				buf.WriteByte(format[i])
 			}
-			hdr.features.Push(match[1])
+			i++
 			continue
+		case c == 'y' && n == 2:
+			buf.WriteString("06")
+		case c == 'y':
+			buf.WriteString("2006")
+		case c == 'M' && n >= 4:
+			buf.WriteString("January")
+		case c == 'M' && n == 3:
+			buf.WriteString("Jan")
+		case c == 'M' && n == 2:
+			buf.WriteString("01")
+		case c == 'M':
+			buf.WriteString("1")
+		case c == 'd' && n == 2:
+			buf.WriteString("02")
+		case c == 'd':
+			buf.WriteString("2")
+		case c == 'H' || c == 'k':
+			buf.WriteString("15")
+		case (c == 'h' || c == 'K') && n == 2:
+			buf.WriteString("03")
+		case c == 'h' || c == 'K':
+			buf.WriteString("3")
+		case c == 'm' && n == 2:
+			buf.WriteString("04")
+		case c == 'm':
+			buf.WriteString("4")
+		case c == 's' && n == 2:
+			buf.WriteString("05")
+		case c == 's':
+			buf.WriteString("5")
+		case c == 'S':
+			buf.WriteString(strings.Repeat("0", n))
+		case c == 'E' && n >= 4:
+			buf.WriteString("Monday")
+		case c == 'E':
+			buf.WriteString("Mon")
+		case c == 'a':
+			buf.WriteString("PM")
+		case c == 'z':
+			buf.WriteString("MST")
+		case c == 'Z':
+			buf.WriteString("-0700")
+		case c == 'X':
+			buf.WriteString("Z07:00")
+		default:
+			buf.WriteString(format[i : i+n])
+		}
+		i += n
+	}
+	return buf.String()
+}
+
+// A value holds a single entry of an instance. The textual form of the value
+// is always held in str. Numeric values are also held in num, as are dates
+// (in seconds since the epoch) and nominal values (as the index of the value
+// in the nominal specification).
+type value struct {
+	missing bool
+	num     float64
+	str     string
+}
+
+// String returns the value as it would appear in a data file
+func (v value) String() string {
+	if v.missing {
+		return "?"
+	}
+	return v.str
+}
+
+// An instance is a single row of a data set, holding one value for each
+// feature of the header along with the weight of the instance.
+type instance struct {
+	values []value
+	weight float64
+}
+
+// parseValue converts s into a value of the feature, checking that it is
+// valid for the declared type. An unquoted ? is a missing value.
+func (f *feature) parseValue(s string, quoted bool) (value, os.Error) {
+	if s == "?" && !quoted {
+		return value{missing: true}, nil
+	}
+	v := value{str: s}
+	var err os.Error
+	switch {
+	case f.isNumeric():
+		v.num, err = strconv.Atof64(s)
+		if err != nil {
+			return v, os.NewError("invalid numeric value " + s +
+				" for attribute " + f.name)
 		}
-		match = relRE.FindStringSubmatch(line)
-		if match != nil {
-			if hdr.name == "" {
-				hdr.name = match[1]
-			} else {
-				log.Fatalf("Double declaration of relation name?\n" +
-					"First: %s\n"+
-					"Second: %s\n", hdr.name, match[1])
+	case f.datatype == typeNominal:
+		i := f.nominalIndex(s)
+		if i < 0 {
+			return v, os.NewError("undeclared nominal value " + s +
+				" for attribute " + f.name)
+		}
+		v.num = float64(i)
+	case f.datatype == typeDate:
+		t, err := time.Parse(f.layout, s)
+		if err != nil {
+			return v, os.NewError("invalid date " + s + " for attribute " +
+				f.name + " (expected format " + f.format + ")")
+		}
+		v.num = float64(t.Seconds())
+	}
+	return v, nil
+}
+
+// zeroValue returns the value taken by the feature when it is left out of a
+// sparse instance. The zero of a date is the epoch, written in the format of
+// the attribute.
+func (f *feature) zeroValue() value {
+	switch {
+	case f.datatype == typeNominal && len(f.nominal) > 0:
+		return value{str: f.nominal[0]}
+	case f.datatype == typeString:
+		return value{}
+	case f.datatype == typeDate:
+		return value{str: time.SecondsToUTC(0).Format(f.layout)}
+	}
+	return value{str: "0"}
+}
+
+// splitFields splits a line on sep, ignoring any separators which fall inside
+// quotes. Each field has the surrounding whitespace removed, but is otherwise
+// left as it was found, quotes included.
+func splitFields(line string, sep byte) ([]string, os.Error) {
+	var fields []string
+	start := 0
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
 			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			fields = append(fields, strings.TrimSpace(line[start:i]))
+			start = i + 1
 		}
-		//TODO: Need to do some parsing of header to create c50 files.
 	}
-	log.Printf("Read in %d attributes\n", len(hdr.features))
-	return hdr
+	if quote != 0 {
+		return nil, os.NewError("unterminated quoted string")
+	}
+	return append(fields, strings.TrimSpace(line[start:])), nil
 }
 
-// writelineSbbFive writes the given line in SBB5 format, storing the data in 
-// datafile and the class label in labelfile. Currently, writelineSbbFive
-// assumes that the last column is the class label.
-func writelineSbbFive(line string,
-	datafile *os.File,
-	labelfile *os.File) {
-	features := strings.Split(line, ",")
+// unquote removes the quotes and escapes from a field returned by splitFields,
+// reporting whether the field was quoted.
+func unquote(field string) (string, bool, os.Error) {
+	if len(field) == 0 || (field[0] != '\'' && field[0] != '"') {
+		return field, false, nil
+	}
+	tok, quoted, rest, err := arffToken(field)
+	if err == nil && strings.TrimSpace(rest) != "" {
+		err = os.NewError("unexpected " + rest + " after quoted string")
+	}
+	return tok, quoted, err
+}
 
-	data := strings.Join(features[0 : len(features)-2], " ")
-	label := features[len(features)-1]
-	_, err = datafile.WriteString(data + "\n")
-	_, err = labelfile.WriteString(label + "\n")
-	errCheck(err)
+// arffQuote quotes s if it could not otherwise be read back from an ARFF file
+func arffQuote(s string) string {
+	if s != "" && s != "?" && strings.IndexAny(s, " \t\r\n,'\"\\%{}") < 0 {
+		return s
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\'', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
 
+// writeArffHeader writes hdr as the header of an ARFF file, ending with the
+// @data line.
+func writeArffHeader(hdr *header, out *bufio.Writer) os.Error {
+	fmt.Fprintf(out, "@relation %s\n\n", arffQuote(hdr.name))
+	for i := 0; i < hdr.features.Len(); i++ {
+		f := hdr.attr(i)
+		datatype := f.datatype
+		switch f.datatype {
+		case typeNominal:
+			values := make([]string, len(f.nominal))
+			for j, v := range f.nominal {
+				values[j] = arffQuote(v)
+			}
+			datatype = "{" + strings.Join(values, ",") + "}"
+		case typeDate:
+			datatype += " " + arffQuote(f.format)
+		}
+		fmt.Fprintf(out, "@attribute %s %s\n", arffQuote(f.name), datatype)
+	}
+	_, err := out.WriteString("\n@data\n")
+	return err
+}
+
+// writelineArff writes the given instance as a line of ARFF data
+func writelineArff(inst *instance, out *bufio.Writer) os.Error {
+	for i, v := range inst.values {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		if v.missing {
+			out.WriteString("?")
+		} else {
+			out.WriteString(arffQuote(v.str))
+		}
+	}
+	if inst.weight != 1 {
+		fmt.Fprintf(out, ",{%s}", strconv.Ftoa64(inst.weight, 'g', -1))
+	}
+	_, err := out.WriteString("\n")
+	return err
+}
+
+// isZero reports whether v is the value a sparse ARFF instance gives to the
+// feature f when it is left out.
+func (f *feature) isZero(v value) bool {
+	if v.missing {
+		return false
+	}
+	switch f.datatype {
+	case typeNominal:
+		return len(f.nominal) > 0 && v.str == f.nominal[0]
+	case typeString:
+		return v.str == ""
+	}
+	return v.num == 0
+}
+
+// writelineSparseArff writes the given instance as a line of sparse ARFF data,
+// listing only the values which are not zero.
+func writelineSparseArff(inst *instance, hdr *header, out *bufio.Writer) os.Error {
+	out.WriteString("{")
+	first := true
+	for i, v := range inst.values {
+		if hdr.attr(i).isZero(v) {
+			continue
+		}
+		if !first {
+			out.WriteString(",")
+		}
+		first = false
+		if v.missing {
+			fmt.Fprintf(out, "%d ?", i)
+		} else {
+			fmt.Fprintf(out, "%d %s", i, arffQuote(v.str))
+		}
+	}
+	out.WriteString("}")
+	if inst.weight != 1 {
+		fmt.Fprintf(out, ",{%s}", strconv.Ftoa64(inst.weight, 'g', -1))
+	}
+	_, err := out.WriteString("\n")
+	return err
+}
+
+// A dataReader reads the instances of a data set one at a time, returning
+// os.EOF once there are none left.
+type dataReader interface {
+	header() *header
+	read() (*instance, os.Error)
+}
+
+// An arffReader reads the instances of an ARFF file, one at a time
+type arffReader struct {
+	hdr  header
+	in   *bufio.Reader
+	line int // The number of the line last read
+}
+
+// newArffReader reads the ARFF header from in, returning a reader which is
+// ready to read the first instance.
+func newArffReader(in *bufio.Reader) (*arffReader, os.Error) {
+	hdr, lines, err := readHeader(in)
+	if err != nil {
+		return nil, err
+	}
+	return &arffReader{hdr, in, lines}, nil
+}
+
+func (r *arffReader) header() *header {
+	return &r.hdr
+}
+
+// read returns the next instance of the data section, skipping blank lines
+// and comments. It returns os.EOF once there are no more instances.
+func (r *arffReader) read() (*instance, os.Error) {
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			return nil, err
+		}
+		r.line++
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '%' {
+			continue
+		}
+		var inst *instance
+		if line[0] == '{' {
+			inst, err = r.parseSparse(line)
+		} else {
+			inst, err = r.parseDense(line)
+		}
+		if err != nil {
+			return nil, &parseError{r.line, err.String()}
+		}
+		return inst, nil
+	}
+	panic("unreachable")
+}
+
+// parseWeight parses an instance weight of the form {w}
+func parseWeight(s string) (float64, os.Error) {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return 0, os.NewError("unexpected " + s + " after instance")
+	}
+	w, err := strconv.Atof64(strings.TrimSpace(s[1 : len(s)-1]))
+	if err != nil {
+		return 0, os.NewError("invalid instance weight " + s)
+	}
+	return w, nil
+}
+
+// parseDense parses a row holding one comma separated value per feature,
+// optionally followed by a weight.
+func (r *arffReader) parseDense(line string) (*instance, os.Error) {
+	fields, err := splitFields(line, ',')
+	if err != nil {
+		return nil, err
+	}
+	n := r.hdr.features.Len()
+	inst := &instance{make([]value, n), 1}
+	if len(fields) == n+1 {
+		if inst.weight, err = parseWeight(fields[n]); err != nil {
+			return nil, err
+		}
+		fields = fields[0:n]
+	}
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d values, found %d", n, len(fields))
+	}
+	for i, field := range fields {
+		s, quoted, err := unquote(field)
+		if err != nil {
+			return nil, err
+		}
+		if inst.values[i], err = r.hdr.attr(i).parseValue(s, quoted); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// closingBrace returns the index of the brace which closes the one opening
+// line, ignoring any braces inside quotes, or -1 if there is none.
+func closingBrace(line string) int {
+	var quote byte
+	for i := 1; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseSparse parses a row of the form {index value, ...}, optionally
+// followed by a weight. Features which are not listed take their zero value.
+func (r *arffReader) parseSparse(line string) (*instance, os.Error) {
+	end := closingBrace(line)
+	if end < 0 {
+		return nil, os.NewError("unterminated sparse instance")
+	}
+	n := r.hdr.features.Len()
+	inst := &instance{make([]value, n), 1}
+	if rest := strings.TrimSpace(line[end+1:]); rest != "" {
+		var err os.Error
+		rest = strings.TrimSpace(strings.TrimLeft(rest, ","))
+		if inst.weight, err = parseWeight(rest); err != nil {
+			return nil, err
+		}
+	}
+	set := make([]bool, n)
+	entries, err := splitFields(line[1:end], ',')
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		sp := strings.IndexAny(entry, " \t")
+		if sp < 0 {
+			return nil, os.NewError("sparse entry " + entry + " has no value")
+		}
+		i, err := strconv.Atoi(entry[0:sp])
+		if err != nil || i < 0 || i >= n {
+			return nil, os.NewError("invalid sparse index in " + entry)
+		}
+		s, quoted, err := unquote(strings.TrimSpace(entry[sp:]))
+		if err != nil {
+			return nil, err
+		}
+		if inst.values[i], err = r.hdr.attr(i).parseValue(s, quoted); err != nil {
+			return nil, err
+		}
+		set[i] = true
+	}
+	for i := 0; i < n; i++ {
+		if !set[i] {
+			inst.values[i] = r.hdr.attr(i).zeroValue()
+		}
+	}
+	return inst, nil
+}
+
+// A codeMap assigns consecutive integer codes to strings, starting from zero
+type codeMap struct {
+	codes  map[string]int
+	values []string // The strings in order of their code
+}
+
+// newCodeMap creates a codeMap which gives the initial strings the codes of
+// their position, and any others the next free code as they are first seen.
+func newCodeMap(initial []string) *codeMap {
+	m := &codeMap{codes: map[string]int{}}
+	for _, s := range initial {
+		m.code(s)
+	}
+	return m
+}
+
+// code returns the integer code of s, assigning a new one if needed
+func (m *codeMap) code(s string) int {
+	c, exists := m.codes[s]
+	if !exists {
+		c = len(m.values)
+		m.codes[s] = c
+		m.values = append(m.values, s)
+	}
+	return c
+}
+
+// A converter writes a data set in one particular output format. The
+// converter is opened with the header of the data set and the names to give
+// its output files. Each instance is then written in turn before the
+// converter is closed.
+type converter interface {
+	open(hdr *header, names *outputNames) os.Error
+	write(inst *instance) os.Error
+	close() os.Error
+}
+
+// A format describes one of the output formats which data sets can be
+// converted to.
+type format struct {
+	name string
+	desc string
+	// The suffix of the main output file, which is the one written to
+	// standard output when the data is read from standard input
+	suffix string
+	// Creates a new converter for the format
+	newConverter func() converter
+	// Asks the user for any options of the format which were not given on
+	// the command line, or nil if there are none
+	configure func()
+}
+
+// The output formats available, in the order they were registered
+var formats []*format
+
+// registerFormat makes an output format available for conversion. Formats
+// register themselves from the init function of the file implementing them.
+func registerFormat(f *format) {
+	formats = append(formats, f)
+}
+
+// findFormat returns the registered format with the given name, or nil
+func findFormat(name string) *format {
+	for _, f := range formats {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Options shared by all conversions
+var (
+	toFlag = flag.String("to", "",
+		"comma separated list of formats to convert to")
+	classFlag = flag.String("class", "last",
+		"attribute holding the class label, by name or index")
+	excludeFlag = flag.String("exclude", "",
+		"comma separated list of attributes to leave out of conversions")
+)
+
+// classIndex returns the index of the feature of hdr named by -class. If it
+// is not given, the class column of any schema of hdr is used. When only some
+// of the columns of the data are read, the class is found among all of them.
+func classIndex(hdr *header) (int, os.Error) {
+	if hdr.whole != nil {
+		class, err := classIndex(hdr.whole)
+		if err != nil {
+			return -1, err
+		}
+		if j := hdr.kept.index(class); j >= 0 {
+			return j, nil
+		}
+		return -1, os.NewError("the class attribute " +
+			hdr.whole.attr(class).name + " is not kept")
+	}
+	if s := hdr.schema; s != nil && !flagSet("class") {
+		if i := s.index(roleClass); i >= 0 {
+			return i, nil
+		}
+	}
+	if *classFlag == "last" {
+		return hdr.features.Len() - 1, nil
+	}
+	cols, err := parseColumnList(*classFlag, hdr)
+	if err != nil {
+		return -1, err
+	}
+	if len(cols) != 1 {
+		return -1, os.NewError("expected a single class attribute")
+	}
+	return cols[0], nil
+}
+
+// excludedColumns returns the set of features of hdr named by -exclude. If
+// it is not given, the identifier and weight columns of any schema of hdr are
+// left out. When only some of the columns of the data are read, these are
+// found among all of them, and those which are not kept are passed over.
+func excludedColumns(hdr *header) (map[int]bool, os.Error) {
+	if hdr.whole != nil {
+		exclude, err := excludedColumns(hdr.whole)
+		if err != nil {
+			return nil, err
+		}
+		kept := map[int]bool{}
+		for j, i := range hdr.kept.keep {
+			if exclude[i] {
+				kept[j] = true
+			}
+		}
+		return kept, nil
+	}
+	cols, err := parseColumnList(*excludeFlag, hdr)
+	if err != nil {
+		return nil, err
+	}
+	exclude := map[int]bool{}
+	for _, i := range cols {
+		exclude[i] = true
+	}
+	if s := hdr.schema; s != nil && !flagSet("exclude") {
+		for i, c := range s.Columns {
+			if c.Role == roleIdentifier || c.Role == roleWeight {
+				exclude[i] = true
+			}
+		}
+	}
+	return exclude, nil
+}
+
+// A projection chooses which columns of the data are kept, in their order
+// in the data
+type projection struct {
+	keep []int
+}
+
+// exclusion returns the projection leaving out the features of hdr given by
+// excludedColumns, or nil if none are. The class is never left out.
+func exclusion(hdr *header) (*projection, os.Error) {
+	class, err := classIndex(hdr)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := excludedColumns(hdr)
+	if err != nil || len(exclude) == 0 {
+		return nil, err
+	}
+	p := new(projection)
+	for i := 0; i < hdr.features.Len(); i++ {
+		if i == class || !exclude[i] {
+			p.keep = append(p.keep, i)
+		}
+	}
+	return p, nil
+}
+
+// header returns the header of the columns kept
+func (p *projection) header(hdr *header) header {
+	projected := header{name: hdr.name}
+	for _, i := range p.keep {
+		projected.features.Push(hdr.attr(i))
+	}
+	return projected
+}
+
+// instance returns the values of inst which are kept
+func (p *projection) instance(inst *instance) *instance {
+	projected := &instance{make([]value, len(p.keep)), inst.weight}
+	for j, i := range p.keep {
+		projected.values[j] = inst.values[i]
+	}
+	return projected
+}
+
+// createFile creates the named output file, along with any directories it is
+// to be written to. Existing files are only overwritten when -force is
+// given. Data files should be created with createOutput instead, so they may
+// be compressed. This is for small files describing the data, and binary
+// formats which need to seek or compress themselves. The name "-" returns
+// standard output.
+func createFile(fileName string) (*os.File, os.Error) {
+	if fileName == stdioName {
+		return takeStdout()
+	}
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !*forceFlag {
+		if _, err := os.Stat(fileName); err == nil {
+			return nil, os.NewError(fileName +
+				" already exists, use -force to overwrite it")
+		}
+		mode = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	if dir := filepath.Dir(fileName); dir != "." {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, err
+		}
+	}
+	debugMsg("Creating: %s", fileName)
+	return os.OpenFile(fileName, mode, 0666)
+}
+
+// closeFile closes a file made by createFile, leaving standard output open
+func closeFile(file *os.File) os.Error {
+	if file == os.Stdout {
+		return nil
+	}
+	return file.Close()
+}
+
+// An sbbFiveWriter writes instances in SBB5 format, storing the features in
+// one file and the class labels in another. Labels are either written as
+// they are, or encoded as the integer codes which SBB expects.
+type sbbFiveWriter struct {
+	class   int
+	exclude map[int]bool
+	numeric bool
+	labels  *codeMap
+	// The number of instances left out for want of a label
+	skipped int
+
+	datafile, labelfile *bufio.Writer
+}
+
+// newSbbFiveWriter creates an sbbFiveWriter which takes the label from the
+// feature with index class and leaves out any features in exclude. When
+// numeric is set, labels are written as integer codes. The codes of nominal
+// labels follow the order of their declaration, other labels are coded in the
+// order they are first seen.
+func newSbbFiveWriter(hdr *header, class int, exclude map[int]bool,
+	numeric bool, datafile, labelfile io.Writer) *sbbFiveWriter {
+	return &sbbFiveWriter{
+		class:     class,
+		exclude:   exclude,
+		numeric:   numeric,
+		labels:    newCodeMap(hdr.attr(class).nominal),
+		datafile:  bufio.NewWriter(datafile),
+		labelfile: bufio.NewWriter(labelfile),
+	}
+}
+
+// write writes the given instance in SBB5 format. Instances which have no
+// class label are left out.
+func (w *sbbFiveWriter) write(inst *instance) os.Error {
+	label := inst.values[w.class]
+	if label.missing {
+		w.skipped++
+		return nil
+	}
+	features := make([]string, 0, len(inst.values)-1)
+	for i, v := range inst.values {
+		if i != w.class && !w.exclude[i] {
+			features = append(features, v.String())
+		}
+	}
+	if _, err := w.datafile.WriteString(strings.Join(features, " ") + "\n"); err != nil {
+		return err
+	}
+	if w.numeric {
+		label.str = strconv.Itoa(w.labels.code(label.str))
+	}
+	_, err := w.labelfile.WriteString(label.str + "\n")
+	return err
+}
+
+// flush writes out anything still buffered by the writer
+func (w *sbbFiveWriter) flush() os.Error {
+	if w.skipped > 0 {
+		log.Printf("Skipped %d instances with no class label\n", w.skipped)
+	}
+	if err := w.datafile.Flush(); err != nil {
+		return err
+	}
+	return w.labelfile.Flush()
+}
+
+// writeMapping writes the code assigned to each label, one per line
+func (w *sbbFiveWriter) writeMapping(mapfile io.Writer) os.Error {
+	out := bufio.NewWriter(mapfile)
+	for c, label := range w.labels.values {
+		fmt.Fprintf(out, "%d %s\n", c, label)
+	}
+	return out.Flush()
+}
+
+var sbbFiveLabelsFlag = flag.String("sbb5-labels", "numeric",
+	"how SBB5 labels are written: numeric codes or nominal names")
+
+// sbbFiveConverter writes the .sbb5.data and .sbb5.labels files, along with
+// a .sbb5.map file giving the code of each label.
+type sbbFiveConverter struct {
+	mapName             string
+	dataFile, labelFile *output
+	w                   *sbbFiveWriter
+}
+
+func (c *sbbFiveConverter) open(hdr *header, names *outputNames) os.Error {
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	exclude, err := excludedColumns(hdr)
+	if err != nil {
+		return err
+	}
+	if *sbbFiveLabelsFlag != "numeric" && *sbbFiveLabelsFlag != "nominal" {
+		return os.NewError("unknown SBB5 label encoding " + *sbbFiveLabelsFlag)
+	}
+	dataName, err := names.name(".sbb5.data")
+	if err != nil {
+		return err
+	}
+	labelName, err := names.name(".sbb5.labels")
+	if err != nil {
+		return err
+	}
+	if c.mapName, err = names.name(".sbb5.map"); err != nil {
+		return err
+	}
+	if c.dataFile, err = createOutput(dataName); err != nil {
+		return err
+	}
+	if c.labelFile, err = createOutput(labelName); err != nil {
+		return err
+	}
+	c.w = newSbbFiveWriter(hdr, class, exclude,
+		*sbbFiveLabelsFlag == "numeric", c.dataFile, c.labelFile)
+	return nil
+}
+
+func (c *sbbFiveConverter) write(inst *instance) os.Error {
+	return c.w.write(inst)
+}
+
+func (c *sbbFiveConverter) close() os.Error {
+	if err := c.w.flush(); err != nil {
+		return err
+	}
+	if err := c.dataFile.Close(); err != nil {
+		return err
+	}
+	if err := c.labelFile.Close(); err != nil {
+		return err
+	}
+	mappingFile, err := createFile(c.mapName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(mappingFile)
+	return c.w.writeMapping(mappingFile)
+}
+
+// arffConverter writes the data set as ARFF, which is mostly useful for
+// turning CSV files into something Weka can read. Sparse ARFF suits data sets
+// which are mostly zeros, such as the flag counts of flow features.
+type arffConverter struct {
+	sparse bool
+	hdr    *header
+	out    *output
+	// The columns written, if any are excluded
+	p *projection
+}
+
+func (c *arffConverter) open(hdr *header, names *outputNames) os.Error {
+	suffix := ".arff"
+	if c.sparse {
+		suffix = ".sparse.arff"
+	}
+	name, err := names.name(suffix)
+	if err != nil {
+		return err
+	}
+	if c.p, err = exclusion(hdr); err != nil {
+		return err
+	}
+	if c.out, err = createOutput(name); err != nil {
+		return err
+	}
+	c.hdr = hdr
+	if c.p != nil {
+		projected := c.p.header(hdr)
+		c.hdr = &projected
+	}
+	return writeArffHeader(c.hdr, c.out.Writer)
+}
+
+func (c *arffConverter) write(inst *instance) os.Error {
+	if c.p != nil {
+		inst = c.p.instance(inst)
+	}
+	if c.sparse {
+		return writelineSparseArff(inst, c.hdr, c.out.Writer)
+	}
+	return writelineArff(inst, c.out.Writer)
+}
+
+func (c *arffConverter) close() os.Error {
+	return c.out.Close()
+}
+
+// jsonAttribute describes a single attribute in a JSON schema file
+type jsonAttribute struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Values []string `json:"values,omitempty"`
+	Format string   `json:"format,omitempty"`
+}
+
+// jsonSchema is written alongside JSON Lines data to describe the objects
+// found in it.
+type jsonSchema struct {
+	Relation    string          `json:"relation"`
+	Class       string          `json:"class"`
+	Attributes  []jsonAttribute `json:"attributes"`
+	Instances   int             `json:"instances"`
+	LabelCounts map[string]int  `json:"label_counts"`
+}
+
+// jsonLinesConverter writes each instance as a JSON object on a line of its
+// own, keyed by attribute name. Numeric values are written as numbers, other
+// values as strings and missing values as null. A .schema.json file describes
+// the attributes and counts the instances of each label.
+type jsonLinesConverter struct {
+	hdr        *header
+	class      int
+	exclude    map[int]bool
+	schemaName string
+	out        *output
+	keys       []string // The quoted name of each attribute
+	instances  int
+	counts     map[string]int
+}
+
+func (c *jsonLinesConverter) open(hdr *header, names *outputNames) os.Error {
+	var err os.Error
+	if c.class, err = classIndex(hdr); err != nil {
+		return err
+	}
+	if c.exclude, err = excludedColumns(hdr); err != nil {
+		return err
+	}
+	c.keys = make([]string, hdr.features.Len())
+	for i := range c.keys {
+		key, err := json.Marshal(hdr.attr(i).name)
+		if err != nil {
+			return err
+		}
+		c.keys[i] = string(key)
+	}
+	name, err := names.name(".jsonl")
+	if err != nil {
+		return err
+	}
+	if c.schemaName, err = names.name(".schema.json"); err != nil {
+		return err
+	}
+	if c.out, err = createOutput(name); err != nil {
+		return err
+	}
+	c.hdr = hdr
+	c.counts = map[string]int{}
+	return nil
+}
+
+func (c *jsonLinesConverter) write(inst *instance) os.Error {
+	c.out.WriteString("{")
+	first := true
+	for i, v := range inst.values {
+		if c.exclude[i] {
+			continue
+		}
+		if !first {
+			c.out.WriteString(",")
+		}
+		first = false
+		c.out.WriteString(c.keys[i] + ":")
+		switch {
+		case v.missing:
+			c.out.WriteString("null")
+		case c.hdr.attr(i).isNumeric() &&
+			!math.IsNaN(v.num) && !math.IsInf(v.num, 0):
+			c.out.WriteString(strconv.Ftoa64(v.num, 'g', -1))
+		default:
+			text, err := json.Marshal(v.str)
+			if err != nil {
+				return err
+			}
+			c.out.Write(text)
+		}
+	}
+	c.instances++
+	if label := inst.values[c.class]; !label.missing {
+		c.counts[label.str]++
+	}
+	_, err := c.out.WriteString("}\n")
+	return err
+}
+
+func (c *jsonLinesConverter) close() os.Error {
+	if err := c.out.Close(); err != nil {
+		return err
+	}
+	schema := jsonSchema{
+		Relation:    c.hdr.name,
+		Class:       c.hdr.attr(c.class).name,
+		Instances:   c.instances,
+		LabelCounts: c.counts,
+	}
+	for i := 0; i < c.hdr.features.Len(); i++ {
+		if c.exclude[i] {
+			continue
+		}
+		f := c.hdr.attr(i)
+		schema.Attributes = append(schema.Attributes,
+			jsonAttribute{f.name, f.datatype, f.nominal, f.format})
+	}
+	text, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	schemaFile, err := createFile(c.schemaName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(schemaFile)
+	_, err = schemaFile.Write(append(text, '\n'))
+	return err
+}
+
+func init() {
+	registerFormat(&format{
+		name:   "sbb5",
+		desc:   "SBB5 (.sbb5.data, .sbb5.labels)",
+		suffix: ".sbb5.data",
+		newConverter: func() converter {
+			return new(sbbFiveConverter)
+		},
+		configure: func() {
+			if !flagSet("sbb5-labels") {
+				fmt.Println("How should the SBB5 labels be written?")
+				fmt.Println("0 : numeric codes")
+				fmt.Println("1 : label names")
+				if promptInt("encoding", "") == 1 {
+					*sbbFiveLabelsFlag = "nominal"
+				}
+			}
+		},
+	})
+	registerFormat(&format{
+		name:   "arff",
+		desc:   "ARFF (.arff)",
+		suffix: ".arff",
+		newConverter: func() converter {
+			return new(arffConverter)
+		},
+	})
+	registerFormat(&format{
+		name:   "sparse-arff",
+		desc:   "Sparse ARFF (.sparse.arff)",
+		suffix: ".sparse.arff",
+		newConverter: func() converter {
+			return &arffConverter{sparse: true}
+		},
+	})
+	registerFormat(&format{
+		name:   "jsonl",
+		desc:   "JSON Lines (.jsonl, .schema.json)",
+		suffix: ".jsonl",
+		newConverter: func() converter {
+			return new(jsonLinesConverter)
+		},
+	})
+}
+
+// isArff reports whether the named file holds ARFF data, judging by its name
+func isArff(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(stripCompression(fileName)), ".arff")
+}
+
+// openDataReader opens the named data file for reading. Files ending in
+// .arff are read as ARFF, anything else is taken to be CSV. Either may be
+// compressed. The name "-" reads standard input. When -schema is given, or
+// CSV data matches a built-in schema, the data is checked against the schema
+// as it is read. Only the columns chosen by -keep, -features and -drop are
+// read. The file should be closed once the caller is done reading.
+func openDataReader(fileName string) (dataReader, io.Closer, os.Error) {
+	reader, dataFile, err := openUncheckedReader(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr := reader.header()
+	if hdr.schema == nil {
+		hdr.schema = datasetSchema()
+	}
+	if hdr.schema != nil {
+		if err = hdr.schema.checkHeader(hdr); err != nil {
+			dataFile.Close()
+			return nil, nil, err
+		}
+		reader = &schemaReader{dataReader: reader, s: hdr.schema}
+	}
+	if reader, err = newProjectReader(reader); err != nil {
+		dataFile.Close()
+		return nil, nil, err
+	}
+	return reader, dataFile, nil
+}
+
+// openConvertReader opens the named data file for conversion, as
+// openDataReader does, scaling the numeric columns read as given by -scaling.
+// Only converted data is scaled, so that stats, report, select and fit see the
+// values as they are.
+func openConvertReader(fileName string) (dataReader, io.Closer, os.Error) {
+	reader, dataFile, err := openDataReader(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reader, err = newScaleReader(reader); err != nil {
+		dataFile.Close()
+		return nil, nil, err
+	}
+	return reader, dataFile, nil
+}
+
+// openUncheckedReader opens the named data file for reading, without
+// checking it against any schema
+func openUncheckedReader(fileName string) (dataReader, io.Closer, os.Error) {
+	if fileName == stdioName {
+		return openStdinReader()
+	}
+	if !isArff(fileName) {
+		dataFile, err := openInput(fileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		buffered := bufio.NewReader(dataFile)
+		s := detectSchema(peekFields(buffered), false)
+		hdr, err := csvHeader(fileName, s)
+		if err != nil {
+			dataFile.Close()
+			return nil, nil, err
+		}
+		return newCsvReader(buffered, hdr), dataFile, nil
+	}
+	dataFile, err := openInput(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := newArffReader(bufio.NewReader(dataFile))
+	if err != nil {
+		dataFile.Close()
+		return nil, nil, err
+	}
+	return reader, dataFile, nil
+}
+
+// A spoolFile is a temporary copy of some input, removed once it is closed
+type spoolFile struct {
+	*os.File
+}
+
+func (f spoolFile) Close() os.Error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// openStdinReader reads data from standard input. The data is read as ARFF
+// if it starts like an ARFF file, and as CSV otherwise. Unless a schema
+// describes every column, CSV data must be read through before it can be
+// converted, so it is first copied to a temporary file.
+func openStdinReader() (dataReader, io.Closer, os.Error) {
+	in, err := openInput(stdioName)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewReader(in)
+	start, _ := buffered.Peek(64)
+	if text := strings.TrimSpace(string(start)); strings.HasPrefix(text, "@") ||
+		strings.HasPrefix(text, "%") {
+		reader, err := newArffReader(buffered)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, in, nil
+	}
+	s := detectSchema(peekFields(buffered), false)
+	if s != nil && len(s.openColumns()) == 0 {
+		hdr, err := csvHeader(stdioName, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newCsvReader(buffered, hdr), in, nil
+	}
+	temp, err := ioutil.TempFile("", "adp")
+	if err != nil {
+		return nil, nil, err
+	}
+	spool := spoolFile{temp}
+	debugMsg("Copying standard input to %s", spool.Name())
+	if _, err = io.Copy(spool, buffered); err == nil {
+		_, err = spool.Seek(0, 0)
+	}
+	if err != nil {
+		spool.Close()
+		return nil, nil, err
+	}
+	hdr, err := csvHeader(spool.Name(), s)
+	if err != nil {
+		spool.Close()
+		return nil, nil, err
+	}
+	hdr.name = relationName(stdioName)
+	return newCsvReader(bufio.NewReader(spool), hdr), spool, nil
+}
+
+// parseFormats parses a comma separated list of formats, each given by its
+// name, or by its position in the menu when menu is set. The positions
+// depend on the order in which the formats are registered, so they are only
+// accepted from the menu that shows them.
+func parseFormats(list string, menu bool) ([]*format, os.Error) {
+	var chosen []*format
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f := findFormat(name)
+		if i, err := strconv.Atoi(name); menu && err == nil &&
+			i >= 0 && i < len(formats) {
+			f = formats[i]
+		}
+		if f == nil {
+			return nil, os.NewError("unknown format: " + name)
+		}
+		chosen = append(chosen, f)
+	}
+	if len(chosen) == 0 {
+		return nil, os.NewError("no output format chosen")
+	}
+	return chosen, nil
+}
+
+// convert writes the data read by reader in each of the given formats,
+// naming the output files after the input. The data is only read once,
+// however many formats are chosen. When the input is standard input and a
+// single format is chosen, its main output is written to standard output.
+func convert(reader dataReader, input string, chosen []*format) os.Error {
+	primary := ""
+	if len(chosen) == 1 {
+		primary = chosen[0].suffix
+	}
+	names := newOutputNames(input, primary)
+	converters := make([]converter, len(chosen))
+	for i, f := range chosen {
+		converters[i] = f.newConverter()
+		if err := converters[i].open(reader.header(), names); err != nil {
+			return err
+		}
+	}
+	count := 0
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range converters {
+			if err = c.write(inst); err != nil {
+				return err
+			}
+		}
+		count++
+	}
+	for _, c := range converters {
+		if err := c.close(); err != nil {
+			return err
+		}
+	}
+	log.Printf("Converted %d instances\n", count)
+	return nil
 }
 
 func interactiveConvert() {
-	fmt.Println("Converting data file from ARFF to multiple formats.")
-	arffFileName := promptString("arff file",
-		"Please enter the path of the ARFF file")
-	debugMsg("Opening file: %s", arffFileName)
-	// Open the file for input and create a buffered reader for the file
-	infileFD, err := os.Open(arffFileName)
+	fmt.Println("Converting data file from ARFF or CSV to multiple formats.")
+	fileName := promptString("data file",
+		"Please enter the path of the ARFF or CSV file")
+	if !isArff(fileName) {
+		interactiveCsvOptions()
+	}
+	debugMsg("Opening file: %s", fileName)
+	reader, dataFile, err := openConvertReader(fileName)
+	errCheck(err)
 	// We do not need this file after, so close it upon leaving this method
-	defer infileFD.Close()
+	defer dataFile.Close()
+	log.Printf("Relation: %s", reader.header().name)
+	menu := *toFlag == ""
+	if menu {
+		fmt.Println("Which formats would you like to convert to?")
+		for i, f := range formats {
+			fmt.Println(i, ":", f.desc)
+		}
+		*toFlag = promptString("formats", "(comma separated)")
+	}
+	chosen, err := parseFormats(*toFlag, menu)
 	errCheck(err)
+	errCheck(checkOutputCount(1, len(chosen)))
+	if !flagSet("class") && reader.header().schema == nil {
+		*classFlag = promptString("class",
+			"Which attribute is the class? (name or index, \"last\" for %s)",
+			reader.header().attr(reader.header().features.Len()-1).name)
+	}
+	if !flagSet("exclude") && reader.header().schema == nil {
+		*excludeFlag = promptString("exclude",
+			"Which attributes should be left out? (ie. IPs and ports, "+
+				"comma separated, \"none\")")
+		if *excludeFlag == "none" {
+			*excludeFlag = ""
+		}
+	}
+	for _, f := range chosen {
+		if f.configure != nil {
+			f.configure()
+		}
+	}
+	errCheck(convert(reader, fileName, chosen))
+}
 
-	infile := bufio.NewReader(infileFD)
-	hdr := readHeader(infile)
-	log.Println(hdr)
-	sbbFiveData, err := os.Create(arffFileName + ".sbb5.data")
+// commandConvert converts each of the files to the formats given by -to
+func commandConvert(files []string) {
+	chosen, err := parseFormats(*toFlag, false)
 	errCheck(err)
-	sbbFiveLabels, err := os.Create(arffFileName + ".sbb5.labels")
-	errCheck(err)
-	for line, err := infile.ReadString('\n'); err == nil; line, err = infile.ReadString('\n') {
-		line = strings.TrimRight(line, "\n ")
-		if len(line) < 1 {
-			continue
-		}
-		writelineSbbFive(line, sbbFiveData, sbbFiveLabels)
+	errCheck(checkOutputCount(len(files), len(chosen)))
+	for _, fileName := range files {
+		reader, dataFile, err := openConvertReader(fileName)
+		errCheck(err)
+		log.Printf("Relation: %s", reader.header().name)
+		errCheck(convert(reader, fileName, chosen))
+		dataFile.Close()
 	}
 }
@@ -0,0 +1,312 @@
+/* 
+ * convert_test.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+var arffTokenTests = []struct {
+	in     string
+	tok    string
+	quoted bool
+	rest   string
+}{
+	{"  plain, next", "plain", false, ", next"},
+	{"'src ip' string", "src ip", true, " string"},
+	{`"say \"hi\"" x`, `say "hi"`, true, " x"},
+	{`'tab\there'`, "tab\there", true, ""},
+	{"{a,b}", "{", false, "a,b}"},
+	{"b}", "b", false, "}"},
+	{"% comment", "", false, ""},
+	{"value % trailing", "value", false, " % trailing"},
+	{"''", "", true, ""},
+	{" ,\t\r\n", "", false, ""},
+}
+
+func TestArffToken(t *testing.T) {
+	for _, tt := range arffTokenTests {
+		tok, quoted, rest, err := arffToken(tt.in)
+		if err != nil {
+			t.Errorf("arffToken(%q): %s", tt.in, err.String())
+			continue
+		}
+		if tok != tt.tok || quoted != tt.quoted || rest != tt.rest {
+			t.Errorf("arffToken(%q) = %q, %v, %q, want %q, %v, %q",
+				tt.in, tok, quoted, rest, tt.tok, tt.quoted, tt.rest)
+		}
+	}
+	if _, _, _, err := arffToken("'open"); err == nil {
+		t.Errorf("arffToken accepted an unterminated quoted string")
+	}
+}
+
+var parseAttributeTests = []struct {
+	in       string
+	name     string
+	datatype string
+	nominal  []string
+	format   string
+}{
+	{"bytes NUMERIC", "bytes", typeNumeric, nil, ""},
+	{"count integer", "count", typeInteger, nil, ""},
+	{"ratio Real", "ratio", typeReal, nil, ""},
+	{"'src ip' string", "src ip", typeString, nil, ""},
+	{"proto {tcp, 'u d,p', icmp} % comment", "proto", typeNominal,
+		[]string{"tcp", "u d,p", "icmp"}, ""},
+	{"empty {}", "empty", typeNominal, nil, ""},
+	{"when date", "when", typeDate, nil, defaultDateFormat},
+	{`when date "yyyy-MM-dd HH:mm:ss"`, "when", typeDate, nil,
+		"yyyy-MM-dd HH:mm:ss"},
+}
+
+func TestParseAttribute(t *testing.T) {
+	for _, tt := range parseAttributeTests {
+		f, err := parseAttribute(tt.in)
+		if err != nil {
+			t.Errorf("parseAttribute(%q): %s", tt.in, err.String())
+			continue
+		}
+		if f.name != tt.name || f.datatype != tt.datatype ||
+			f.format != tt.format || len(f.nominal) != len(tt.nominal) {
+			t.Errorf("parseAttribute(%q) = %+v", tt.in, f)
+			continue
+		}
+		for i, v := range tt.nominal {
+			if f.nominal[i] != v {
+				t.Errorf("parseAttribute(%q): nominal value %d is %q, want %q",
+					tt.in, i, f.nominal[i], v)
+			}
+		}
+	}
+}
+
+var parseAttributeErrors = []struct {
+	in  string
+	err string
+}{
+	{"", "missing attribute name"},
+	{"a", "missing type for attribute a"},
+	{"a blob", "unknown type blob for attribute a"},
+	{"a relational", "relational attributes are not supported"},
+	{"a {x, y", "unterminated nominal specification for attribute a"},
+	{"a {x, x}", "duplicate nominal value x for attribute a"},
+	{"a numeric extra", "unexpected extra after attribute a"},
+	{"'a string", "unterminated quoted string"},
+}
+
+func TestParseAttributeErrors(t *testing.T) {
+	for _, tt := range parseAttributeErrors {
+		_, err := parseAttribute(tt.in)
+		if err == nil {
+			t.Errorf("parseAttribute(%q) succeeded, want %q", tt.in, tt.err)
+		} else if err.String() != tt.err {
+			t.Errorf("parseAttribute(%q): %q, want %q", tt.in, err.String(), tt.err)
+		}
+	}
+}
+
+const sampleHeader = `% A comment before the header
+@RELATION 'flows data'
+
+@attribute 'src ip' string
+@attribute proto {tcp, udp, icmp}
+@attribute when date "yyyy-MM-dd HH:mm:ss"
+@attribute class {a,b}
+@data
+1.2.3.4,tcp,"2011-01-02 03:04:05",a
+`
+
+func TestReadHeader(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader(sampleHeader))
+	hdr, lines, err := readHeader(in)
+	if err != nil {
+		t.Fatalf("readHeader: %s", err.String())
+	}
+	if hdr.name != "flows data" || lines != 8 || hdr.features.Len() != 4 {
+		t.Fatalf("readHeader = %q with %d attributes after %d lines",
+			hdr.name, hdr.features.Len(), lines)
+	}
+	if i := hdr.attrIndex("class"); i != 3 {
+		t.Errorf("attrIndex(class) = %d, want 3", i)
+	}
+	if i := hdr.attrIndex("missing"); i != -1 {
+		t.Errorf("attrIndex(missing) = %d, want -1", i)
+	}
+	// The data section must be left for the caller
+	if line, _ := in.ReadString('\n'); !strings.HasPrefix(line, "1.2.3.4") {
+		t.Errorf("readHeader left %q", line)
+	}
+}
+
+var readHeaderErrors = []struct {
+	in  string
+	err string
+}{
+	{"@relation x\n@attribute a foo\n@data\n",
+		"line 2: unknown type foo for attribute a"},
+	{"@relation x\n@relation y\n",
+		"line 2: double declaration of relation name (first: x, second: y)"},
+	{"@relation x\n@attribute a numeric\n@attribute a string\n",
+		"line 3: duplicate attribute name a"},
+	{"@relation\n", "line 1: missing relation name"},
+	{"@relation x\n@attribute a numeric\n", "line 2: missing @data section"},
+	{"@relation x\n@bogus\n", "line 2: unexpected @bogus"},
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	for _, tt := range readHeaderErrors {
+		_, _, err := readHeader(bufio.NewReader(strings.NewReader(tt.in)))
+		if err == nil {
+			t.Errorf("readHeader(%q) succeeded, want %q", tt.in, tt.err)
+		} else if err.String() != tt.err {
+			t.Errorf("readHeader(%q): %q, want %q", tt.in, err.String(), tt.err)
+		}
+	}
+}
+
+var goDateLayoutTests = []struct {
+	format string
+	layout string
+}{
+	{defaultDateFormat, "2006-01-02T15:04:05"},
+	{"yyyy-MM-dd HH:mm:ss", "2006-01-02 15:04:05"},
+	{"yyyy-MM-dd'T'HH:mm:ss.SSS", "2006-01-02T15:04:05.000"},
+	{"dd/MM/yy h:mm a", "02/01/06 3:04 PM"},
+	{"EEE, d MMM yyyy HH:mm:ss Z", "Mon, 2 Jan 2006 15:04:05 -0700"},
+	{"MMMM d, yyyy", "January 2, 2006"},
+	{"HH 'o''clock'", "15 o'clock"},
+}
+
+func TestGoDateLayout(t *testing.T) {
+	for _, tt := range goDateLayoutTests {
+		if layout := goDateLayout(tt.format); layout != tt.layout {
+			t.Errorf("goDateLayout(%q) = %q, want %q", tt.format, layout, tt.layout)
+		}
+	}
+}
+
+const sampleData = `@relation test
+@attribute 'src ip' string
+@attribute proto {tcp, 'u d,p', icmp}
+@attribute bytes numeric
+@attribute when date "yyyy-MM-dd HH:mm:ss"
+@attribute class {a,b}
+@data
+1.2.3.4, tcp, 5, "2011-01-02 03:04:05", a
+'9.9.9.9','u d,p',?,?,b
+% A comment between instances
+
+'it\'s',icmp,-1.5e3,'2011-01-02 03:04:06',a, {2.5}
+{1 icmp, 2 7}
+{0 '{x}', 4 b}, {0.5}
+{}
+`
+
+var sampleInstances = []struct {
+	values []string
+	nums   []float64
+	weight float64
+}{
+	{[]string{"1.2.3.4", "tcp", "5", "2011-01-02 03:04:05", "a"},
+		[]float64{0, 0, 5, 1293937445, 0}, 1},
+	{[]string{"9.9.9.9", "u d,p", "?", "?", "b"},
+		[]float64{0, 1, 0, 0, 1}, 1},
+	{[]string{"it's", "icmp", "-1.5e3", "2011-01-02 03:04:06", "a"},
+		[]float64{0, 2, -1500, 1293937446, 0}, 2.5},
+	{[]string{"", "icmp", "7", "1970-01-01 00:00:00", "a"},
+		[]float64{0, 2, 7, 0, 0}, 1},
+	{[]string{"{x}", "tcp", "0", "1970-01-01 00:00:00", "b"},
+		[]float64{0, 0, 0, 0, 1}, 0.5},
+	{[]string{"", "tcp", "0", "1970-01-01 00:00:00", "a"},
+		[]float64{0, 0, 0, 0, 0}, 1},
+}
+
+func TestArffReader(t *testing.T) {
+	r, err := newArffReader(bufio.NewReader(strings.NewReader(sampleData)))
+	if err != nil {
+		t.Fatalf("newArffReader: %s", err.String())
+	}
+	for n, want := range sampleInstances {
+		inst, err := r.read()
+		if err != nil {
+			t.Fatalf("instance %d: %s", n, err.String())
+		}
+		if inst.weight != want.weight {
+			t.Errorf("instance %d: weight %v, want %v", n, inst.weight, want.weight)
+		}
+		for i, v := range inst.values {
+			if v.String() != want.values[i] || v.num != want.nums[i] {
+				t.Errorf("instance %d, value %d: %q (%v), want %q (%v)",
+					n, i, v.String(), v.num, want.values[i], want.nums[i])
+			}
+		}
+	}
+	if _, err := r.read(); err != os.EOF {
+		t.Errorf("read after the last instance: %v, want EOF", err)
+	}
+}
+
+var arffReaderErrors = []struct {
+	row string
+	err string
+}{
+	{"1,p", "line 6: expected 3 values, found 2"},
+	{"1,p,?,?", "line 6: unexpected ? after instance"},
+	{"x,p,?", "line 6: invalid numeric value x for attribute a"},
+	{"1,r,?", "line 6: undeclared nominal value r for attribute b"},
+	{"1,p,?,{w}", "line 6: invalid instance weight {w}"},
+	{"'1,p,?", "line 6: unterminated quoted string"},
+	{"1,'p'q,?", "line 6: unexpected q after quoted string"},
+	{"{0 1, 3 x}", "line 6: invalid sparse index in 3 x"},
+	{"{0}", "line 6: sparse entry 0 has no value"},
+	{"{0 1", "line 6: unterminated sparse instance"},
+	{"1,p,2001-01-01", "line 6: invalid date 2001-01-01 for attribute c " +
+		"(expected format yyyy-MM-dd HH:mm)"},
+}
+
+func TestArffReaderErrors(t *testing.T) {
+	const hdr = "@relation x\n@attribute a numeric\n@attribute b {p,q}\n" +
+		"@attribute c date 'yyyy-MM-dd HH:mm'\n@data\n"
+	for _, tt := range arffReaderErrors {
+		r, err := newArffReader(bufio.NewReader(strings.NewReader(hdr + tt.row)))
+		if err != nil {
+			t.Fatalf("newArffReader: %s", err.String())
+		}
+		_, err = r.read()
+		if err == nil {
+			t.Errorf("read(%q) succeeded, want %q", tt.row, tt.err)
+		} else if err.String() != tt.err {
+			t.Errorf("read(%q): %q, want %q", tt.row, err.String(), tt.err)
+		}
+	}
+}
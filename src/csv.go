@@ -0,0 +1,426 @@
+/* 
+ * csv.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// csvOptions describes the layout of the CSV files being read
+type csvOptions struct {
+	// Set if the first line of the file names the columns
+	headerRow bool
+	// The name of a file listing the columns, which takes precedence over
+	// any header row
+	namesFile string
+	// Columns holding no more than this many distinct values are taken to be
+	// nominal, rather than strings
+	maxNominal int
+}
+
+// The options used when reading CSV files
+var csvOpts = csvOptions{maxNominal: 20}
+
+func init() {
+	flag.BoolVar(&csvOpts.headerRow, "header", false,
+		"the first line of CSV input names the columns")
+	flag.StringVar(&csvOpts.namesFile, "names", "",
+		"file naming the columns of CSV input, one per line")
+	flag.IntVar(&csvOpts.maxNominal, "max-nominal", 20,
+		"most distinct values a CSV column may hold to be taken as nominal")
+}
+
+// A csvReader reads the instances of a CSV file, such as those produced by
+// labelFile, according to a header describing its columns.
+type csvReader struct {
+	hdr  header
+	in   *bufio.Reader
+	line int // The number of the line last read
+	// Set while the header row is still to be skipped
+	skipHeader bool
+}
+
+func newCsvReader(in *bufio.Reader, hdr header) *csvReader {
+	return &csvReader{hdr: hdr, in: in, skipHeader: csvOpts.headerRow}
+}
+
+func (r *csvReader) header() *header {
+	return &r.hdr
+}
+
+// read returns the next instance of the file, skipping blank lines. Empty
+// values and ? are taken to be missing. It returns os.EOF once there are no
+// more instances.
+func (r *csvReader) read() (*instance, os.Error) {
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			return nil, err
+		}
+		r.line++
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if r.skipHeader {
+			r.skipHeader = false
+			continue
+		}
+		inst, err := r.parse(line)
+		if err != nil {
+			return nil, &parseError{r.line, err.String()}
+		}
+		return inst, nil
+	}
+	panic("unreachable")
+}
+
+func (r *csvReader) parse(line string) (*instance, os.Error) {
+	fields, err := splitFields(line, ',')
+	if err != nil {
+		return nil, err
+	}
+	n := r.hdr.features.Len()
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d values, found %d", n, len(fields))
+	}
+	inst := &instance{make([]value, n), 1}
+	for i, field := range fields {
+		s, quoted, err := unquote(field)
+		if err != nil {
+			return nil, err
+		}
+		if s == "" && !quoted {
+			inst.values[i].missing = true
+			continue
+		}
+		if inst.values[i], err = r.hdr.attr(i).parseValue(s, quoted); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// readNamesFile reads a file listing the columns of a CSV file, one per line
+// in the form "name [type]". The type may be any ARFF type other than nominal
+// or date, and overrides the type which would otherwise be inferred. Lines
+// starting with # are comments.
+func readNamesFile(fileName string) (names []string, types []string, err os.Error) {
+	debugMsg("Opening file: %s", fileName)
+	namesFile, err := openInput(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer namesFile.Close()
+	namesReader := bufio.NewReader(namesFile)
+	lineCount := 0
+	for {
+		line, err := namesReader.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			if err != os.EOF {
+				return nil, nil, err
+			}
+			return names, types, nil
+		}
+		lineCount++
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		datatype := ""
+		if len(fields) > 1 {
+			datatype = strings.ToLower(fields[1])
+			switch datatype {
+			case typeNumeric, typeInteger, typeReal, typeString:
+			default:
+				return nil, nil, &parseError{lineCount,
+					"unsupported column type " + fields[1]}
+			}
+		}
+		names = append(names, fields[0])
+		types = append(types, datatype)
+	}
+	panic("unreachable")
+}
+
+// A columnGuess keeps track of what a CSV column may hold while inferring its
+// type.
+type columnGuess struct {
+	numeric, integer, ip bool
+	// The distinct values seen, or nil once there are too many to be nominal
+	distinct *codeMap
+}
+
+// observe narrows down the type of the column given one of its values
+func (g *columnGuess) observe(s string, maxNominal int) {
+	if g.integer {
+		_, err := strconv.Atoi64(s)
+		g.integer = err == nil
+	}
+	if g.numeric {
+		_, err := strconv.Atof64(s)
+		g.numeric = err == nil
+	}
+	if g.ip {
+		g.ip = net.ParseIP(s) != nil
+	}
+	if g.distinct != nil {
+		g.distinct.code(s)
+		if maxNominal >= 0 && len(g.distinct.values) > maxNominal {
+			g.distinct = nil
+		}
+	}
+}
+
+// datatype returns the ARFF type which best fits the values observed
+func (g *columnGuess) datatype() string {
+	switch {
+	case g.integer:
+		return typeInteger
+	case g.numeric:
+		return typeNumeric
+	case g.ip || g.distinct == nil:
+		return typeString
+	}
+	return typeNominal
+}
+
+// inferCsvHeader reads through the named CSV file to build a header for it.
+// Columns are typed as integer or numeric if all of their values are numbers,
+// as strings if they hold IP addresses or too many distinct values to be
+// nominal, and as nominal otherwise. The last column is taken to be the class
+// label and is never a string. The columns are named by csvOpts.namesFile or
+// the header row if there is one, and numbered otherwise.
+func inferCsvHeader(fileName string) (header, os.Error) {
+	var hdr header
+	var names, types []string
+	if csvOpts.namesFile != "" {
+		var err os.Error
+		names, types, err = readNamesFile(csvOpts.namesFile)
+		if err != nil {
+			return hdr, err
+		}
+	}
+	debugMsg("Scanning file: %s", fileName)
+	dataFile, err := openInput(fileName)
+	if err != nil {
+		return hdr, err
+	}
+	defer dataFile.Close()
+	dataReader := bufio.NewReader(dataFile)
+	var guesses []*columnGuess
+	lineCount := 0
+	for {
+		line, err := dataReader.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			if err != os.EOF {
+				return hdr, err
+			}
+			break
+		}
+		lineCount++
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields, err := splitFields(line, ',')
+		if err != nil {
+			return hdr, &parseError{lineCount, err.String()}
+		}
+		for i := range fields {
+			if fields[i], _, err = unquote(fields[i]); err != nil {
+				return hdr, &parseError{lineCount, err.String()}
+			}
+		}
+		if guesses == nil {
+			guesses = make([]*columnGuess, len(fields))
+			for i := range guesses {
+				guesses[i] = &columnGuess{true, true, true, newCodeMap(nil)}
+			}
+			if csvOpts.headerRow {
+				if names == nil {
+					names = fields
+				}
+				continue
+			}
+		} else if len(fields) != len(guesses) {
+			return hdr, &parseError{lineCount, fmt.Sprintf(
+				"expected %d values, found %d", len(guesses), len(fields))}
+		}
+		for i, s := range fields {
+			if s == "" || s == "?" {
+				continue
+			}
+			maxNominal := csvOpts.maxNominal
+			if i == len(fields)-1 {
+				maxNominal = -1
+			}
+			guesses[i].observe(s, maxNominal)
+		}
+	}
+	if guesses == nil {
+		return hdr, os.NewError(fileName + " holds no data")
+	}
+	if names != nil && len(names) != len(guesses) {
+		return hdr, fmt.Errorf("%d column names given for %d columns",
+			len(names), len(guesses))
+	}
+	hdr.name = relationName(fileName)
+	for i, g := range guesses {
+		f := &feature{name: fmt.Sprintf("attr%d", i), datatype: g.datatype()}
+		if names != nil {
+			f.name = names[i]
+		}
+		if i == len(guesses)-1 && f.datatype == typeString {
+			f.datatype = typeNominal
+		}
+		if types != nil && types[i] != "" {
+			f.datatype = types[i]
+		}
+		if f.datatype == typeNominal {
+			f.nominal = g.distinct.values
+		}
+		hdr.features.Push(f)
+	}
+	return hdr, nil
+}
+
+// relationName returns the name of the relation held in the named file
+func relationName(fileName string) string {
+	if fileName == stdioName {
+		return "stdin"
+	}
+	name := filepath.Base(stripCompression(fileName))
+	return name[0 : len(name)-len(filepath.Ext(name))]
+}
+
+// csvHeader returns the header describing the named CSV file, which is taken
+// from the schema s if it is not nil, and otherwise inferred from the data.
+// Where the schema leaves out the values of a nominal or label column, they
+// are found by reading through the data.
+func csvHeader(fileName string, s *schema) (header, os.Error) {
+	if s == nil {
+		return inferCsvHeader(fileName)
+	}
+	hdr := header{name: s.Relation, schema: s}
+	if hdr.name == "" {
+		hdr.name = relationName(fileName)
+	}
+	for i := range s.Columns {
+		hdr.features.Push(s.Columns[i].feature())
+	}
+	open := s.openColumns()
+	if len(open) == 0 {
+		return hdr, nil
+	}
+	values, err := scanCsvValues(fileName, open)
+	if err != nil {
+		return hdr, err
+	}
+	for j, i := range open {
+		f := hdr.attr(i)
+		f.datatype, f.nominal = typeNominal, values[j].values
+	}
+	return hdr, nil
+}
+
+// scanCsvValues returns the distinct values found in each of the given
+// columns of the named CSV file
+func scanCsvValues(fileName string, columns []int) ([]*codeMap, os.Error) {
+	debugMsg("Scanning file: %s", fileName)
+	dataFile, err := openInput(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+	dataReader := bufio.NewReader(dataFile)
+	values := make([]*codeMap, len(columns))
+	for j := range values {
+		values[j] = newCodeMap(nil)
+	}
+	skipHeader := csvOpts.headerRow
+	for lineCount := 1; ; lineCount++ {
+		line, err := dataReader.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			if err != os.EOF {
+				return nil, err
+			}
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if skipHeader {
+			skipHeader = false
+			continue
+		}
+		fields, err := splitFields(line, ',')
+		if err != nil {
+			return nil, &parseError{lineCount, err.String()}
+		}
+		for j, i := range columns {
+			if i >= len(fields) {
+				return nil, &parseError{lineCount, "missing values"}
+			}
+			s, quoted, err := unquote(fields[i])
+			if err != nil {
+				return nil, &parseError{lineCount, err.String()}
+			}
+			if (s != "" && s != "?") || quoted {
+				values[j].code(s)
+			}
+		}
+	}
+	return values, nil
+}
+
+// interactiveCsvOptions asks the user how the CSV file being read is laid
+// out, unless this was given on the command line.
+func interactiveCsvOptions() {
+	if flagSet("names") || flagSet("header") {
+		return
+	}
+	csvOpts.namesFile = promptString("names file",
+		"Please enter the path of a file naming the columns (\"none\" to "+
+			"use a header row or number the columns)")
+	if csvOpts.namesFile == "none" {
+		csvOpts.namesFile = ""
+	}
+	fmt.Println("Does the first line of the file name the columns?")
+	fmt.Println("0 : no")
+	fmt.Println("1 : yes")
+	csvOpts.headerRow = promptInt("header row", "") == 1
+}
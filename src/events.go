@@ -0,0 +1,390 @@
+/* 
+ * events.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var eventsFlag = flag.String("events", "",
+	"CSV file of known events (srcip,srcport,dstip,dstport,proto,start,end,label) "+
+		"to label flows by, ahead of the rules")
+
+// The names the columns of a flow's addresses, ports and protocol have in the
+// schemas adp knows of
+var (
+	srcAddrColumns = []string{"srcip", "id.orig_h"}
+	srcPortColumns = []string{"srcport", "id.orig_p"}
+	dstAddrColumns = []string{"dstip", "id.resp_h"}
+	dstPortColumns = []string{"dstport", "id.resp_p"}
+	protoColumns   = []string{"proto"}
+)
+
+// The numbers of the protocols which may be given by name
+var protoNumbers = map[string]string{
+	"icmp": "1", "tcp": "6", "udp": "17", "icmp6": "58", "ipv6-icmp": "58",
+	"sctp": "132",
+}
+
+// A groundEvent is a known event, such as an attack, which labels the flows
+// between its addresses and ports during its time. A port or protocol left
+// empty or given as * matches any, as does one of the addresses. Events
+// without times match flows at any time.
+type groundEvent struct {
+	line                int // The line of the events file it is on
+	src, dst            string
+	sport, dport, proto string
+	start, end          float64
+	timed               bool
+	label               string
+	matched             int // The number of flows it labeled
+}
+
+// An eventTable holds the events, found by their addresses
+type eventTable struct {
+	events []*groundEvent
+	// The events with both addresses given, keyed by the pair, and those
+	// with one, keyed by it
+	byAddrs map[string][]*groundEvent
+	// The columns of the flows' addresses, ports, protocol, start time and
+	// duration in the schema they were last found in
+	s                                   *schema
+	src, sport, dst, dport, proto, time int
+	duration                            int
+}
+
+// addrPair returns the key of the events between two addresses, in either
+// direction
+func addrPair(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + " " + b
+}
+
+// normalAddr returns an address in the form it is compared in, or "" for a
+// wildcard
+func normalAddr(s string) (string, os.Error) {
+	if s == "" || s == "*" {
+		return "", nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", os.NewError("invalid address " + s)
+	}
+	return ip.String(), nil
+}
+
+// normalProto returns a protocol in the form it is compared in, as a number
+func normalProto(s string) string {
+	if n, known := protoNumbers[strings.ToLower(s)]; known {
+		return n
+	}
+	return ruleValue(s)
+}
+
+// eventTime returns a time of an event in seconds, given as seconds since
+// the epoch or as a date in the default ARFF format
+func eventTime(s string) (float64, os.Error) {
+	if t, err := strconv.Atof64(s); err == nil {
+		return t, nil
+	}
+	f := &feature{name: "time", datatype: typeDate, format: defaultDateFormat,
+		layout: goDateLayout(defaultDateFormat)}
+	v, err := f.parseValue(s, false)
+	return v.num, err
+}
+
+// parseEvent reads an event from the fields of a line of the events file
+func parseEvent(fields []string) (*groundEvent, os.Error) {
+	if len(fields) != 8 {
+		return nil, fmt.Errorf("expected 8 values, found %d", len(fields))
+	}
+	for i := range fields {
+		var err os.Error
+		if fields[i], _, err = unquote(fields[i]); err != nil {
+			return nil, err
+		}
+		if fields[i] == "*" {
+			fields[i] = ""
+		}
+	}
+	e := &groundEvent{sport: ruleValue(fields[1]), dport: ruleValue(fields[3]),
+		proto: normalProto(fields[4]), label: fields[7]}
+	var err os.Error
+	if e.src, err = normalAddr(fields[0]); err != nil {
+		return nil, err
+	}
+	if e.dst, err = normalAddr(fields[2]); err != nil {
+		return nil, err
+	}
+	if e.src == "" && e.dst == "" {
+		return nil, os.NewError("an event needs at least one address")
+	}
+	if e.label == "" {
+		return nil, os.NewError("the event has no label")
+	}
+	if fields[5] != "" || fields[6] != "" {
+		if fields[5] == "" || fields[6] == "" {
+			return nil, os.NewError("an event needs both a start and an end time")
+		}
+		if e.start, err = eventTime(fields[5]); err != nil {
+			return nil, err
+		}
+		if e.end, err = eventTime(fields[6]); err != nil {
+			return nil, err
+		}
+		e.timed = true
+	}
+	return e, nil
+}
+
+// loadEvents reads the named events file. Lines starting with # are comments,
+// and a first line naming the columns is skipped.
+func loadEvents(fileName string) (*eventTable, os.Error) {
+	debugMsg("Opening file: %s", fileName)
+	in, err := openInput(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+	t := &eventTable{byAddrs: map[string][]*groundEvent{}}
+	for lineCount := 1; ; lineCount++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			if err != os.EOF {
+				return nil, err
+			}
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := splitFields(line, ',')
+		if err != nil {
+			return nil, &parseError{lineCount, err.String()}
+		}
+		if len(t.events) == 0 && strings.ToLower(fields[0]) == "srcip" {
+			continue
+		}
+		e, err := parseEvent(fields)
+		if err != nil {
+			return nil, &parseError{lineCount, err.String()}
+		}
+		e.line = lineCount
+		key := e.src + e.dst
+		if e.src != "" && e.dst != "" {
+			key = addrPair(e.src, e.dst)
+		}
+		t.byAddrs[key] = append(t.byAddrs[key], e)
+		t.events = append(t.events, e)
+	}
+	log.Printf("Read %d events\n", len(t.events))
+	return t, nil
+}
+
+// findColumn returns the index of the first of the named columns in s, or -1
+func findColumn(s *schema, names []string) int {
+	for _, name := range names {
+		if i := s.hdr.attrIndex(name); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// useSchema finds the columns of the flows described by s. Timed events
+// cannot be matched against flows without a numeric or date timestamp.
+func (t *eventTable) useSchema(s *schema) os.Error {
+	if s == nil {
+		return os.NewError("labeling by events needs a schema naming the " +
+			"addresses and ports of the flows, given by -schema")
+	}
+	if s == t.s {
+		return nil
+	}
+	t.s = s
+	t.src, t.dst = findColumn(s, srcAddrColumns), findColumn(s, dstAddrColumns)
+	t.sport, t.dport = findColumn(s, srcPortColumns), findColumn(s, dstPortColumns)
+	t.proto = findColumn(s, protoColumns)
+	if t.src < 0 || t.dst < 0 || t.sport < 0 || t.dport < 0 || t.proto < 0 {
+		return os.NewError("the schema does not name the addresses, ports " +
+			"and protocol of the flows")
+	}
+	t.time = s.index(roleTimestamp)
+	t.duration = s.hdr.attrIndex("duration")
+	// Timed events can only be matched against flows with usable times
+	why := ""
+	if t.time < 0 {
+		why = "the flows have no timestamp column"
+	} else if f := s.hdr.attr(t.time); !f.isNumeric() && f.datatype != typeDate {
+		why = "the timestamp column " + f.name + " of the flows is neither " +
+			"a number nor a date (Argus times are numbers when written by " +
+			"ra -u)"
+	}
+	if why == "" {
+		return nil
+	}
+	for _, e := range t.events {
+		if e.timed {
+			return os.NewError(why + ", so timed events cannot be matched")
+		}
+	}
+	t.time = -1
+	log.Printf("The flows have no timestamp, so events are matched " +
+		"by their addresses, ports and protocol alone\n")
+	return nil
+}
+
+// A flowTuple is what a flow is matched against events by
+type flowTuple struct {
+	src, dst, sport, dport, proto string
+	start, end                    float64
+	timed                         bool
+}
+
+// tuple reads the addresses, ports, protocol and time of a flow from the
+// fields of its line. The flow ends its duration, in seconds, after it
+// starts.
+func (t *eventTable) tuple(fields []string) (*flowTuple, os.Error) {
+	value := func(i int) (string, os.Error) {
+		if i >= len(fields) {
+			return "", os.NewError("missing values")
+		}
+		v, _, err := unquote(fields[i])
+		if v == "?" {
+			v = ""
+		}
+		return v, err
+	}
+	// The source and destination addresses and ports, and the protocol
+	var v [5]string
+	for j, i := range []int{t.src, t.dst, t.sport, t.dport, t.proto} {
+		var err os.Error
+		if v[j], err = value(i); err != nil {
+			return nil, err
+		}
+	}
+	f := &flowTuple{sport: ruleValue(v[2]), dport: ruleValue(v[3]),
+		proto: normalProto(v[4])}
+	var err os.Error
+	if f.src, err = normalAddr(v[0]); err != nil {
+		return nil, err
+	}
+	if f.dst, err = normalAddr(v[1]); err != nil {
+		return nil, err
+	}
+	if t.time < 0 {
+		return f, nil
+	}
+	start, err := value(t.time)
+	if err != nil || start == "" {
+		return f, err
+	}
+	ts, err := t.s.hdr.attr(t.time).parseValue(start, false)
+	if err != nil {
+		return nil, err
+	}
+	f.start, f.end, f.timed = ts.num, ts.num, true
+	if t.duration >= 0 {
+		if d, err := value(t.duration); err == nil && d != "" {
+			if secs, err := strconv.Atof64(d); err == nil {
+				f.end += secs
+			}
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether the event matches the flow, in the direction
+// given or the reverse
+func (e *groundEvent) matches(f *flowTuple) bool {
+	if e.proto != "" && e.proto != f.proto {
+		return false
+	}
+	if e.timed && f.timed && (f.start > e.end || f.end < e.start) {
+		return false
+	}
+	same := func(want, got string) bool {
+		return want == "" || want == got
+	}
+	return (same(e.src, f.src) && same(e.sport, f.sport) &&
+		same(e.dst, f.dst) && same(e.dport, f.dport)) ||
+		(same(e.src, f.dst) && same(e.sport, f.dport) &&
+			same(e.dst, f.src) && same(e.dport, f.sport))
+}
+
+// label returns the label of the first event in the file which matches the
+// flow on the line with the given fields, or "" if none does
+func (t *eventTable) label(fields []string) (string, os.Error) {
+	f, err := t.tuple(fields)
+	if err != nil || f.src == "" || f.dst == "" {
+		return "", err
+	}
+	var found *groundEvent
+	for _, key := range []string{addrPair(f.src, f.dst), f.src, f.dst} {
+		for _, e := range t.byAddrs[key] {
+			if e.matches(f) {
+				if found == nil || e.line < found.line {
+					found = e
+				}
+				break
+			}
+		}
+	}
+	if found == nil {
+		return "", nil
+	}
+	found.matched++
+	return found.label, nil
+}
+
+// report logs the events which matched no flows
+func (t *eventTable) report() {
+	var unmatched []*groundEvent
+	for _, e := range t.events {
+		if e.matched == 0 {
+			unmatched = append(unmatched, e)
+		}
+	}
+	log.Printf("%d of %d events matched flows\n",
+		len(t.events)-len(unmatched), len(t.events))
+	for _, e := range unmatched {
+		log.Printf("No flows matched the %s event on line %d of %s\n",
+			e.label, e.line, *eventsFlag)
+	}
+}
@@ -0,0 +1,146 @@
+/* 
+ * flowSchema.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"log"
+	"strings"
+)
+
+// The columns of the flow statistics written by NetMate, as found in
+// test.csv. Each flow is identified by its addresses, ports and protocol,
+// followed by statistics of its forward (f) and backward (b) directions:
+// packet lengths (pktl) and inter-arrival times (iat), the times it was
+// active and idle, subflow sizes, PSH and URG flag counts and header lengths.
+// Times are in microseconds. Labeled flows have a label as a further column.
+var flowColumns = []schemaColumn{
+	{Name: "srcip", Type: columnIP, Role: roleIdentifier},
+	{Name: "srcport", Type: columnPort},
+	{Name: "dstip", Type: columnIP, Role: roleIdentifier},
+	{Name: "dstport", Type: columnPort},
+	{Name: "proto", Type: columnInt},
+	{Name: "total_fpackets", Type: columnInt},
+	{Name: "total_fvolume", Type: columnInt},
+	{Name: "total_bpackets", Type: columnInt},
+	{Name: "total_bvolume", Type: columnInt},
+	{Name: "min_fpktl", Type: columnInt},
+	{Name: "mean_fpktl", Type: columnFloat},
+	{Name: "max_fpktl", Type: columnInt},
+	{Name: "std_fpktl", Type: columnFloat},
+	{Name: "min_bpktl", Type: columnInt},
+	{Name: "mean_bpktl", Type: columnFloat},
+	{Name: "max_bpktl", Type: columnInt},
+	{Name: "std_bpktl", Type: columnFloat},
+	{Name: "min_fiat", Type: columnFloat},
+	{Name: "mean_fiat", Type: columnFloat},
+	{Name: "max_fiat", Type: columnFloat},
+	{Name: "std_fiat", Type: columnFloat},
+	{Name: "min_biat", Type: columnFloat},
+	{Name: "mean_biat", Type: columnFloat},
+	{Name: "max_biat", Type: columnFloat},
+	{Name: "std_biat", Type: columnFloat},
+	{Name: "duration", Type: columnFloat},
+	{Name: "min_active", Type: columnFloat},
+	{Name: "mean_active", Type: columnFloat},
+	{Name: "max_active", Type: columnFloat},
+	{Name: "std_active", Type: columnFloat},
+	{Name: "min_idle", Type: columnFloat},
+	{Name: "mean_idle", Type: columnFloat},
+	{Name: "max_idle", Type: columnFloat},
+	{Name: "std_idle", Type: columnFloat},
+	{Name: "sflow_fpackets", Type: columnFloat},
+	{Name: "sflow_fbytes", Type: columnFloat},
+	{Name: "sflow_bpackets", Type: columnFloat},
+	{Name: "sflow_bbytes", Type: columnFloat},
+	{Name: "fpsh_cnt", Type: columnInt},
+	{Name: "bpsh_cnt", Type: columnInt},
+	{Name: "furg_cnt", Type: columnInt},
+	{Name: "burg_cnt", Type: columnInt},
+	{Name: "total_fhlen", Type: columnInt},
+	{Name: "total_bhlen", Type: columnInt},
+	{Name: "dscp", Type: columnInt},
+	{Name: "label", Type: columnLabel, Role: roleClass},
+}
+
+// The schemas built into adp, which may be named by -schema in place of a
+// schema file
+var builtinSchemas = map[string]*schema{}
+
+func init() {
+	flow := &schema{Columns: flowColumns}
+	errCheck(flow.validate())
+	builtinSchemas["flow"] = flow
+}
+
+// detectSchema returns the schema of data whose first line has the given
+// fields. This is the schema given by -schema if there is one. Otherwise the
+// fields are compared with each built-in schema, which is used if they match
+// its column count and the shape of its values. When unlabeled is set, the
+// data is expected to lack the class column. Detection is turned off by
+// giving -schema none.
+func detectSchema(fields []string, unlabeled bool) *schema {
+	if s := datasetSchema(); s != nil || *schemaFlag == "none" {
+		return s
+	}
+	for name, s := range builtinSchemas {
+		if s.checkRow(fields, !unlabeled) == nil {
+			log.Printf("The data matches the built-in %s schema\n", name)
+			return s
+		}
+	}
+	return nil
+}
+
+// peekFields returns the fields of the first line of CSV data waiting to be
+// read, without reading it. Blank lines and any header row are skipped.
+func peekFields(in *bufio.Reader) []string {
+	start, _ := in.Peek(4096)
+	skipHeader := csvOpts.headerRow
+	line := ""
+	for _, l := range strings.Split(string(start), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if skipHeader {
+			skipHeader = false
+			continue
+		}
+		line = l
+		break
+	}
+	if line == "" {
+		return nil
+	}
+	fields, err := splitFields(line, ',')
+	if err != nil {
+		return nil
+	}
+	return fields
+}
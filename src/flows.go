@@ -0,0 +1,394 @@
+/* 
+ * flows.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options for building flows from captures, in seconds
+var (
+	flowIdleFlag = flag.Float64("flow-idle", 600,
+		"seconds without a packet after which a flow ends")
+	flowActiveFlag = flag.Float64("flow-active", 1800,
+		"seconds after which a flow ends, even if it is still active")
+	subflowIdleFlag = flag.Float64("subflow-idle", 1,
+		"seconds without a packet after which a flow is counted as idle")
+)
+
+// runningStats keeps the minimum, maximum, mean and standard deviation of a
+// series of numbers without storing them, using Welford's method.
+type runningStats struct {
+	n        int
+	min, max float64
+	mean, m2 float64
+}
+
+func (s *runningStats) add(x float64) {
+	s.n++
+	if s.n == 1 || x < s.min {
+		s.min = x
+	}
+	if s.n == 1 || x > s.max {
+		s.max = x
+	}
+	delta := x - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (x - s.mean)
+}
+
+// std returns the population standard deviation of the numbers added
+func (s *runningStats) std() float64 {
+	if s.n == 0 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.n))
+}
+
+// wholeNumber formats x rounded to the nearest whole number
+func wholeNumber(x float64) string {
+	return strconv.Itoa64(int64(math.Floor(x + 0.5)))
+}
+
+// fields returns the minimum, mean, maximum and standard deviation, which
+// are all zero if nothing was added
+func (s *runningStats) fields() []string {
+	return []string{wholeNumber(s.min), wholeNumber(s.mean),
+		wholeNumber(s.max), wholeNumber(s.std())}
+}
+
+// A flow gathers statistics of the packets travelling in both directions
+// between two ports. The forward direction is that of the first packet.
+type flow struct {
+	src, dst     []byte
+	sport, dport int
+	proto        int
+	dscp         int
+	// The times of the first and last packets, and of the start of the
+	// current active period, in microseconds
+	first, last, activeStart int64
+	// The times of the last packet in each direction
+	flast, blast int64
+
+	fpackets, bpackets int
+	fvolume, bvolume   int64
+	fhlen, bhlen       int64
+	fpsh, bpsh         int
+	furg, burg         int
+
+	fpktl, bpktl runningStats
+	fiat, biat   runningStats
+	active, idle runningStats
+}
+
+func newFlow(p *packet) *flow {
+	// The packet data belongs to the capture, so keep copies of the addresses
+	f := &flow{
+		src:         append([]byte(nil), p.src...),
+		dst:         append([]byte(nil), p.dst...),
+		sport:       p.sport,
+		dport:       p.dport,
+		proto:       p.proto,
+		dscp:        p.dscp,
+		first:       p.ts,
+		last:        p.ts,
+		activeStart: p.ts,
+	}
+	return f
+}
+
+// add adds a packet travelling in the forward direction if forward is set,
+// and backwards otherwise. Any gap since the previous packet longer than
+// -subflow-idle ends an active period of the flow.
+func (f *flow) add(p *packet, forward bool) {
+	if gap := p.ts - f.last; gap > int64(*subflowIdleFlag*1e6) {
+		f.active.add(float64(f.last - f.activeStart))
+		f.idle.add(float64(gap))
+		f.activeStart = p.ts
+	}
+	if p.ts > f.last {
+		f.last = p.ts
+	}
+	psh, urg := 0, 0
+	if p.flags&tcpPsh != 0 {
+		psh = 1
+	}
+	if p.flags&tcpUrg != 0 {
+		urg = 1
+	}
+	if forward {
+		if f.fpackets > 0 {
+			f.fiat.add(float64(p.ts - f.flast))
+		}
+		f.flast = p.ts
+		f.fpackets++
+		f.fvolume += int64(p.length)
+		f.fhlen += int64(p.headerLen)
+		f.fpktl.add(float64(p.length))
+		f.fpsh += psh
+		f.furg += urg
+	} else {
+		if f.bpackets > 0 {
+			f.biat.add(float64(p.ts - f.blast))
+		}
+		f.blast = p.ts
+		f.bpackets++
+		f.bvolume += int64(p.length)
+		f.bhlen += int64(p.headerLen)
+		f.bpktl.add(float64(p.length))
+		f.bpsh += psh
+		f.burg += urg
+	}
+}
+
+// write writes the statistics of the flow as a line of CSV, in the layout
+// of the flow schema. Statistics are rounded to whole numbers, as they are
+// by NetMate.
+func (f *flow) write(out *bufio.Writer) os.Error {
+	// Close the final active period
+	active := f.active
+	active.add(float64(f.last - f.activeStart))
+	subflows := float64(f.idle.n + 1)
+	fields := []string{
+		net.IP(f.src).String(), strconv.Itoa(f.sport),
+		net.IP(f.dst).String(), strconv.Itoa(f.dport),
+		strconv.Itoa(f.proto),
+		strconv.Itoa(f.fpackets), strconv.Itoa64(f.fvolume),
+		strconv.Itoa(f.bpackets), strconv.Itoa64(f.bvolume),
+	}
+	fields = append(fields, f.fpktl.fields()...)
+	fields = append(fields, f.bpktl.fields()...)
+	fields = append(fields, f.fiat.fields()...)
+	fields = append(fields, f.biat.fields()...)
+	fields = append(fields, strconv.Itoa64(f.last-f.first))
+	fields = append(fields, active.fields()...)
+	fields = append(fields, f.idle.fields()...)
+	fields = append(fields,
+		wholeNumber(float64(f.fpackets)/subflows),
+		wholeNumber(float64(f.fvolume)/subflows),
+		wholeNumber(float64(f.bpackets)/subflows),
+		wholeNumber(float64(f.bvolume)/subflows),
+		strconv.Itoa(f.fpsh), strconv.Itoa(f.bpsh),
+		strconv.Itoa(f.furg), strconv.Itoa(f.burg),
+		strconv.Itoa64(f.fhlen), strconv.Itoa64(f.bhlen),
+		strconv.Itoa(f.dscp))
+	_, err := out.WriteString(strings.Join(fields, ",") + "\n")
+	return err
+}
+
+// flowsByStart sorts flows by the time of their first packet
+type flowsByStart []*flow
+
+func (s flowsByStart) Len() int           { return len(s) }
+func (s flowsByStart) Less(i, j int) bool { return s[i].first < s[j].first }
+func (s flowsByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// flowKey returns the key of the flow a packet from src to dst belongs to,
+// when travelling in the forward direction
+func flowKey(src, dst []byte, sport, dport, proto int) string {
+	return string(src) + string(dst) + string([]byte{byte(sport >> 8),
+		byte(sport), byte(dport >> 8), byte(dport), byte(proto)})
+}
+
+// A flowTable assembles packets into flows, writing each flow out once it
+// ends. A flow ends once it has been idle for -flow-idle, or has lasted for
+// -flow-active, after which any further packets start a new flow.
+type flowTable struct {
+	flows map[string]*flow
+	out   *bufio.Writer
+	// The time the table was last swept for flows which have ended
+	swept             int64
+	idle, activeLimit int64
+	count             int
+}
+
+func newFlowTable(out *bufio.Writer) *flowTable {
+	return &flowTable{
+		flows:       map[string]*flow{},
+		out:         out,
+		idle:        int64(*flowIdleFlag * 1e6),
+		activeLimit: int64(*flowActiveFlag * 1e6),
+	}
+}
+
+// ended reports whether the flow has ended by the time now
+func (t *flowTable) ended(f *flow, now int64) bool {
+	return now-f.last > t.idle || now-f.first > t.activeLimit
+}
+
+// add adds the packet to the flow it belongs to, starting a new flow if
+// there is none
+func (t *flowTable) add(p *packet) os.Error {
+	key := flowKey(p.src, p.dst, p.sport, p.dport, p.proto)
+	f, forward := t.flows[key], true
+	if f == nil {
+		reverse := flowKey(p.dst, p.src, p.dport, p.sport, p.proto)
+		if f = t.flows[reverse]; f != nil {
+			key, forward = reverse, false
+		}
+	}
+	if f != nil && t.ended(f, p.ts) {
+		t.flows[key] = nil, false
+		if err := t.emit([]*flow{f}); err != nil {
+			return err
+		}
+		f = nil
+	}
+	if f == nil {
+		key, forward = flowKey(p.src, p.dst, p.sport, p.dport, p.proto), true
+		f = newFlow(p)
+		t.flows[key] = f
+	}
+	f.add(p, forward)
+	// Look for other flows which have ended every so often, so that long
+	// captures need not hold every flow in memory
+	if p.ts-t.swept > t.idle/10 {
+		t.swept = p.ts
+		return t.sweep(p.ts)
+	}
+	return nil
+}
+
+// sweep writes out and forgets every flow which has ended by the time now
+func (t *flowTable) sweep(now int64) os.Error {
+	var ended []*flow
+	for key, f := range t.flows {
+		if t.ended(f, now) {
+			ended = append(ended, f)
+			t.flows[key] = nil, false
+		}
+	}
+	return t.emit(ended)
+}
+
+// flush writes out every flow still in the table
+func (t *flowTable) flush() os.Error {
+	var flows []*flow
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	t.flows = map[string]*flow{}
+	return t.emit(flows)
+}
+
+// emit writes out the flows in the order they started
+func (t *flowTable) emit(flows []*flow) os.Error {
+	sort.Sort(flowsByStart(flows))
+	for _, f := range flows {
+		if err := f.write(t.out); err != nil {
+			return err
+		}
+		t.count++
+	}
+	return nil
+}
+
+// captureFlows writes the flows found in the named capture file as CSV, in
+// the layout of the flow schema. When reading standard input the flows are
+// written to standard output. If the capture cannot be read to the end, the
+// flows written so far are removed rather than left looking complete.
+func captureFlows(fileName string) os.Error {
+	debugMsg("Opening file: %s", fileName)
+	in, err := openInput(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	source, err := openCapture(in)
+	if err != nil {
+		return os.NewError(fileName + ": " + err.String())
+	}
+	names := newOutputNames(fileName, ".csv")
+	csvName, err := names.name(".csv")
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(csvName)
+	if err != nil {
+		return err
+	}
+	err = writeFlows(fileName, source, out.Writer)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && out.name != stdioName {
+		os.Remove(out.name)
+	}
+	return err
+}
+
+// writeFlows writes the flows of the packets read from source, which come
+// from the named capture file
+func writeFlows(fileName string, source packetSource, out *bufio.Writer) os.Error {
+	table := newFlowTable(out)
+	var p packet
+	packets, skipped := 0, 0
+	for {
+		c, err := source.next()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return os.NewError(fileName + ": " + err.String())
+		}
+		packets++
+		if !decodePacket(c, &p) {
+			skipped++
+			continue
+		}
+		if err = table.add(&p); err != nil {
+			return err
+		}
+	}
+	if err := table.flush(); err != nil {
+		return err
+	}
+	log.Printf("Built %d flows from %d packets, of which %d were not TCP "+
+		"or UDP\n", table.count, packets, skipped)
+	return nil
+}
+
+// commandFlows builds flows from each of the capture files
+func commandFlows(files []string) {
+	for _, fileName := range files {
+		errCheck(captureFlows(fileName))
+	}
+}
+
+func init() {
+	commands["flows"] = command{"build flow statistics from pcap captures",
+		commandFlows}
+}
@@ -29,6 +29,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -36,20 +37,24 @@ import (
 )
 
 var (
-	featureToValueMap map[int]map[int]string
+	featureToValueMap map[int]map[string]string
+	groundTruth       *eventTable
 	err               os.Error
 	inputInt          int
 	inputString       string
 )
 
+var rulesFlag = flag.String("r", "label.rules", "file holding the labeling rules")
+
 // This uses the quick method where maps are used. This is only for sets
 // of rules which have no conflicts and have a simple format "if coloumn
-// x = value then label is y.
-func quickRules(filepath string) map[int]map[int]string {
+// x = value then label is y. Values may be numbers, such as ports, or any
+// other text, such as addresses or service names.
+func quickRules(filepath string) map[int]map[string]string {
 	// list to return which contains the parsed rules
 	debugMsg("Opening file \"" + filepath + "\"")
 	// Open the rule file
-	dataFile, err := os.Open(filepath)
+	dataFile, err := openInput(filepath)
 	errCheck(err)
 	defer dataFile.Close()
 	// Create a buffered reader for the rule file
@@ -57,7 +62,7 @@ func quickRules(filepath string) map[int]map[int]string {
 	// A map which points features to another map which contains possible values 
 	// for that feature.
 	// map[featureindex->[value->label]]
-	featToValMap := map[int]map[int]string{}
+	featToValMap := map[int]map[string]string{}
 	// Read in the contents
 	for line, err := dataReader.ReadString('\n'); // read line by line
 	err == nil;                                   // loop until end of file or error
@@ -80,17 +85,16 @@ func quickRules(filepath string) map[int]map[int]string {
 					debugMsg("\tlabel = %s", fields[2])
 					debugMsg("}")
 					// Read in some values
-					featureIndex, err := strconv.Atoi(features[i])
-					errCheck(err)
-					value, err := strconv.Atoi(fields[1])
+					featureIndex, err := ruleColumn(features[i])
 					errCheck(err)
+					value := ruleValue(fields[1])
 					label := fields[2]
 					errCheck(err)
 					_, exists := featToValMap[featureIndex]
 					if exists {
 						featToValMap[featureIndex][value] = label
 					} else {
-						featToValMap[featureIndex] = map[int]string{value: label}
+						featToValMap[featureIndex] = map[string]string{value: label}
 					}
 				}
 			} else {
@@ -103,42 +107,105 @@ func quickRules(filepath string) map[int]map[int]string {
 	return featToValMap
 }
 
-func labelFile(fileName string){
+// ruleColumn returns the index of the column a rule refers to, given either
+// by its index or by its name. Names are those of the schema given by
+// -schema, or of the built-in flow schema if there is none.
+func ruleColumn(name string) (int, os.Error) {
+	if i, err := strconv.Atoi(name); err == nil {
+		return i, nil
+	}
+	s := datasetSchema()
+	if s == nil {
+		s = builtinSchemas["flow"]
+	}
+	if i := s.hdr.attrIndex(name); i >= 0 {
+		return i, nil
+	}
+	return -1, os.NewError("unknown column " + name + " in rules")
+}
+
+// ruleValue returns the form in which a value is compared with the values of
+// the rules. Integers are compared by their value, so that 080 matches 80, and
+// anything else as it is written.
+func ruleValue(s string) string {
+	if n, err := strconv.Atoi64(s); err == nil {
+		return strconv.Itoa64(n)
+	}
+	return s
+}
+
+// labelFile writes a copy of the named file with a label added to the end of
+// every line. When reading standard input the copy is written to standard
+// output. When -schema is given, or the data matches a built-in schema, each
+// labeled line is checked against it. Flows matching a ground truth event are
+// given its label, and the rest are labeled by the rules. Only the columns
+// chosen by -keep, -features and -drop are written.
+func labelFile(fileName string) {
 	debugMsg("Opening file: %s", fileName)
 	// Open the file for input and create a buffered reader for the file
-	dataFile, err := os.Open(fileName)
+	dataFile, err := openInput(fileName)
 	errCheck(err)
 	// We do not need this file after, so close it upon leaving this method
 	defer dataFile.Close()
 	dataReader := bufio.NewReader(dataFile)
-	debugMsg("Opening file: %s", dataFile.Name()+".labeled")
-	labeledFile, err := os.Create(dataFile.Name()+".labeled")
+	names := newOutputNames(fileName, ".labeled")
+	labeledName, err := names.name(".labeled")
+	errCheck(err)
+	labeledFile, err := createOutput(labeledName)
 	errCheck(err)
-	debugMsg("Writing to file: %s", labeledFile.Name())
+	debugMsg("Writing to file: %s", labeledFile.name)
 	debugMsg("Labeling... this may take a while")
 	// We do not need this file after, so close it upon leaving this method
 	defer labeledFile.Close()
+	var s *schema
+	// The columns written, if only some of them are
+	var p *projection
 	// Create a variable for the line read, and the label assigned
 	var line, label string
+	lineCount := 0
 	// Loop over each line of the file
 	for line, err = dataReader.ReadString('\n'); // read line by line
 	err == nil;                                  // stop on error or end of file
 	line, err = dataReader.ReadString('\n') {
+		lineCount++
 		line = strings.TrimRight(line, "\n")
 		// Split the line into it's feature values
-		feature := strings.Split(line, ",")
+		feature, err := splitFields(line, ',')
+		if err != nil {
+			errCheck(&parseError{lineCount, err.String()})
+		}
 		// FIXME: fix the way we deal with malformed lines
 		if len(feature) < 5 {
 			debugMsg("Skipping line due to abnormal formation")
 			break
 		}
+		if lineCount == 1 {
+			s = detectSchema(feature, true)
+			if groundTruth != nil {
+				errCheck(groundTruth.useSchema(s))
+			}
+			class := -1
+			if s != nil {
+				class = s.index(roleClass)
+			}
+			p = lineProjection(s, feature, class)
+		}
 		//Find the rule that satisfies the current individual, if any.
 		for ruleFeature, ruleValMap := range featureToValueMap {
-			instanceFeatVal, err := strconv.Atoi(feature[ruleFeature])
-			errCheck(err)
+			instanceFeatVal, quoted, err := unquote(feature[ruleFeature])
+			if err != nil {
+				errCheck(&parseError{lineCount, err.String()})
+			}
+			if !quoted && (instanceFeatVal == "?" || instanceFeatVal == "") {
+				// Missing values match no rule
+				if label == "" {
+					label = "OTHER"
+				}
+				continue
+			}
 			// Try to find the corresponding value in the map for the current
 			// feature index.
-			valLabel, exists := ruleValMap[instanceFeatVal]
+			valLabel, exists := ruleValMap[ruleValue(instanceFeatVal)]
 			if exists {
 				label = valLabel
 				break
@@ -146,11 +213,33 @@ func labelFile(fileName string){
 				label = "OTHER"
 			}
 		}
+		// Ground truth events take precedence over the rules
+		if groundTruth != nil {
+			eventLabel, err := groundTruth.label(feature)
+			if err != nil {
+				errCheck(&parseError{lineCount, err.String()})
+			}
+			if eventLabel != "" {
+				label = eventLabel
+			}
+		}
+		if label == "" {
+			label = "OTHER"
+		}
+		if s != nil {
+			if err = s.checkRow(append(feature, label), true); err != nil {
+				errCheck(&parseError{lineCount, err.String()})
+			}
+		}
+		if p != nil {
+			line = strings.Join(p.fields(feature), ",")
+		}
 		// Write labeled line to labeled file
 		_, err = labeledFile.WriteString(line + "," + label + "\n")
 		errCheck(err)
 		label = ""
 	}
+	errCheck(labeledFile.Close())
 }
 
 //state 1 - Label a data set
@@ -159,12 +248,19 @@ func interactiveLabelDataSet() {
 	fmt.Println("Which rule method would you like to use?")
 	fmt.Println("0 : quick rules")
 	//fmt.Println("1 : extended rules (unfinished)")
+	fmt.Println("2 : ground truth events, then quick rules")
 	fmt.Print("> ")
 	_, err = Scanf("%d", &inputInt)
 	errCheck(err)
 	switch inputInt {
 	case 0:
-		featureToValueMap = quickRules("label.rules")
+		featureToValueMap = quickRules(*rulesFlag)
+	case 2:
+		*eventsFlag = promptString("events file",
+			"Please enter the location of the file which lists the events")
+		groundTruth, err = loadEvents(*eventsFlag)
+		errCheck(err)
+		featureToValueMap = quickRules(*rulesFlag)
 	}
 
 	// Begin labeling the data set
@@ -176,4 +272,26 @@ func interactiveLabelDataSet() {
 	_, err = Scanf("%s", &inputString)
 	errCheck(err)
 	labelFile(inputString)
-}
\ No newline at end of file
+	if groundTruth != nil {
+		groundTruth.report()
+	}
+}
+
+// commandLabel labels each of the files with the events given by -events
+// and the rules given by -r. The rules are left out when there are events,
+// unless -r is given too.
+func commandLabel(files []string) {
+	if *eventsFlag != "" {
+		groundTruth, err = loadEvents(*eventsFlag)
+		errCheck(err)
+	}
+	if groundTruth == nil || flagSet("r") {
+		featureToValueMap = quickRules(*rulesFlag)
+	}
+	for _, fileName := range files {
+		labelFile(fileName)
+	}
+	if groundTruth != nil {
+		groundTruth.report()
+	}
+}
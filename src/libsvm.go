@@ -0,0 +1,264 @@
+/* 
+ * libsvm.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// A libsvmWriter writes instances in the sparse LIBSVM / SVMlight format,
+// "label [qid:n] index:value ...". Feature indexes start from one, and values
+// which are zero are left out. Labels, nominal and string values are written
+// as integer codes, which are kept so they can be saved. Feature values are
+// coded from one, so that no value is mistaken for one which was left out,
+// and dates are written as seconds since the epoch.
+type libsvmWriter struct {
+	hdr   *header
+	class int
+	qid   int // The feature used to group instances, or -1 for none
+	// The LIBSVM index of each feature, or zero if it is not written
+	index []int
+	// The codes of labels, query groups and the values of each feature
+	labels *codeMap
+	qids   *codeMap
+	values map[int]*codeMap
+	// The number of instances left out for want of a label
+	skipped int
+	// The number of missing values of each feature, which read back as zero
+	missing []int
+
+	out *bufio.Writer
+}
+
+// newLibsvmWriter creates a libsvmWriter which takes the label from the
+// feature with index class and leaves out any features in exclude. If qid is
+// not -1, instances are grouped by the values of that feature.
+func newLibsvmWriter(hdr *header, class int, qid int, exclude map[int]bool,
+	outfile io.Writer) *libsvmWriter {
+	w := &libsvmWriter{
+		hdr:     hdr,
+		class:   class,
+		qid:     qid,
+		index:   make([]int, hdr.features.Len()),
+		missing: make([]int, hdr.features.Len()),
+		labels:  newCodeMap(hdr.attr(class).nominal),
+		qids:    newCodeMap(nil),
+		values:  map[int]*codeMap{},
+		out:     bufio.NewWriter(outfile),
+	}
+	next := 1
+	for i := range w.index {
+		if i == class || i == qid || exclude[i] {
+			continue
+		}
+		w.index[i] = next
+		next++
+		if t := hdr.attr(i).datatype; t == typeNominal || t == typeString {
+			w.values[i] = newCodeMap(hdr.attr(i).nominal)
+		}
+	}
+	return w
+}
+
+// write writes the given instance as a line of LIBSVM data. Instances which
+// have no class label are left out.
+func (w *libsvmWriter) write(inst *instance) os.Error {
+	label := inst.values[w.class]
+	if label.missing {
+		w.skipped++
+		return nil
+	}
+	if w.hdr.attr(w.class).isNumeric() {
+		w.out.WriteString(label.str)
+	} else {
+		w.out.WriteString(strconv.Itoa(w.labels.code(label.str)))
+	}
+	if w.qid >= 0 {
+		// Instances with no group all share a group of their own
+		fmt.Fprintf(w.out, " qid:%d", w.qids.code(inst.values[w.qid].String())+1)
+	}
+	for i, v := range inst.values {
+		if w.index[i] == 0 {
+			continue
+		}
+		if v.missing {
+			w.missing[i]++
+			continue
+		}
+		text := v.str
+		if codes, exists := w.values[i]; exists {
+			text = strconv.Itoa(codes.code(v.str) + 1)
+		} else if v.num == 0 {
+			continue
+		} else if w.hdr.attr(i).datatype == typeDate {
+			text = strconv.Ftoa64(v.num, 'f', -1)
+		}
+		fmt.Fprintf(w.out, " %d:%s", w.index[i], text)
+	}
+	_, err := w.out.WriteString("\n")
+	return err
+}
+
+// flush writes out anything still buffered by the writer, reporting the
+// instances and values which could not be written as they were.
+func (w *libsvmWriter) flush() os.Error {
+	if w.skipped > 0 {
+		log.Printf("Skipped %d instances with no class label\n", w.skipped)
+	}
+	for i, n := range w.missing {
+		if n > 0 {
+			log.Printf("Warning: %d missing values of attribute %s were "+
+				"written as zero, as LIBSVM has no missing values\n",
+				n, w.hdr.attr(i).name)
+		}
+	}
+	return w.out.Flush()
+}
+
+// writeMapping writes the codes used in the LIBSVM data, so that results can
+// be traced back to the original data. Each line is one of:
+//   label <code> <label>
+//   feature <index> <name>
+//   value <index> <code> <value>
+//   qid <code> <value>
+func (w *libsvmWriter) writeMapping(mapfile io.Writer) os.Error {
+	out := bufio.NewWriter(mapfile)
+	if !w.hdr.attr(w.class).isNumeric() {
+		for c, label := range w.labels.values {
+			fmt.Fprintf(out, "label %d %s\n", c, label)
+		}
+	}
+	for i, index := range w.index {
+		if index == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "feature %d %s\n", index, w.hdr.attr(i).name)
+		if codes, exists := w.values[i]; exists {
+			for c, v := range codes.values {
+				fmt.Fprintf(out, "value %d %d %s\n", index, c+1, v)
+			}
+		}
+	}
+	if w.qid >= 0 {
+		for c, v := range w.qids.values {
+			fmt.Fprintf(out, "qid %d %s\n", c+1, v)
+		}
+	}
+	return out.Flush()
+}
+
+var libsvmQidFlag = flag.String("libsvm-qid", "",
+	"attribute which groups LIBSVM instances into queries")
+
+// libsvmConverter writes the .libsvm data file and the .libsvm.map file
+// recording the codes used within it.
+type libsvmConverter struct {
+	mapName    string
+	libsvmFile *output
+	w          *libsvmWriter
+}
+
+func (c *libsvmConverter) open(hdr *header, names *outputNames) os.Error {
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	exclude, err := excludedColumns(hdr)
+	if err != nil {
+		return err
+	}
+	qid := -1
+	if *libsvmQidFlag != "" {
+		cols, err := parseColumnList(*libsvmQidFlag, hdr)
+		if err != nil {
+			return err
+		}
+		if len(cols) != 1 {
+			return os.NewError("expected a single qid attribute")
+		}
+		qid = cols[0]
+	}
+	name, err := names.name(".libsvm")
+	if err != nil {
+		return err
+	}
+	if c.mapName, err = names.name(".libsvm.map"); err != nil {
+		return err
+	}
+	if c.libsvmFile, err = createOutput(name); err != nil {
+		return err
+	}
+	c.w = newLibsvmWriter(hdr, class, qid, exclude, c.libsvmFile)
+	return nil
+}
+
+func (c *libsvmConverter) write(inst *instance) os.Error {
+	return c.w.write(inst)
+}
+
+func (c *libsvmConverter) close() os.Error {
+	if err := c.w.flush(); err != nil {
+		return err
+	}
+	if err := c.libsvmFile.Close(); err != nil {
+		return err
+	}
+	mappingFile, err := createFile(c.mapName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(mappingFile)
+	return c.w.writeMapping(mappingFile)
+}
+
+func init() {
+	registerFormat(&format{
+		name:   "libsvm",
+		desc:   "LIBSVM / SVMlight (.libsvm)",
+		suffix: ".libsvm",
+		newConverter: func() converter {
+			return new(libsvmConverter)
+		},
+		configure: func() {
+			if !flagSet("libsvm-qid") {
+				*libsvmQidFlag = promptString("qid",
+					"Which attribute groups instances into queries? "+
+						"(\"none\")")
+				if *libsvmQidFlag == "none" {
+					*libsvmQidFlag = ""
+				}
+			}
+		},
+	})
+}
@@ -0,0 +1,523 @@
+/* 
+ * netflow.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var netflowOutputFlag = flag.String("netflow-output", "csv",
+	"format flows read from NetFlow and IPFIX files are written in: csv or arff")
+
+// A flowRecord holds the columns of the flow schema, other than the label,
+// for a flow read from an export file. Columns the export does not provide
+// are missing.
+type flowRecord struct {
+	values []string
+	// Counts and times in the units of the export, or -1 if not exported
+	fpackets, fvolume int64
+	bpackets, bvolume int64
+	start, end        int64 // In milliseconds
+}
+
+func newFlowRecord() *flowRecord {
+	r := &flowRecord{
+		values:   make([]string, len(flowColumns)-1),
+		fpackets: -1, fvolume: -1, bpackets: -1, bvolume: -1,
+		start: -1, end: -1,
+	}
+	for i := range r.values {
+		r.values[i] = "?"
+	}
+	return r
+}
+
+// set sets the value of the named column of the flow schema
+func (r *flowRecord) set(name string, value string) {
+	r.values[builtinSchemas["flow"].hdr.attrIndex(name)] = value
+}
+
+// finish fills in the columns which are worked out from the counts and times
+// of the record
+func (r *flowRecord) finish() {
+	count := func(name string, n int64) {
+		if n >= 0 {
+			r.set(name, strconv.Itoa64(n))
+		}
+	}
+	count("total_fpackets", r.fpackets)
+	count("total_fvolume", r.fvolume)
+	count("total_bpackets", r.bpackets)
+	count("total_bvolume", r.bvolume)
+	if r.fpackets > 0 && r.fvolume >= 0 {
+		r.set("mean_fpktl", wholeNumber(float64(r.fvolume)/float64(r.fpackets)))
+	}
+	if r.bpackets > 0 && r.bvolume >= 0 {
+		r.set("mean_bpktl", wholeNumber(float64(r.bvolume)/float64(r.bpackets)))
+	}
+	if r.start >= 0 && r.end >= r.start {
+		r.set("duration", strconv.Itoa64((r.end-r.start)*1000))
+	}
+}
+
+// Information elements shared by NetFlow v9 and IPFIX templates
+const (
+	ieOctets          = 1
+	iePackets         = 2
+	ieProtocol        = 4
+	ieTos             = 5
+	ieSrcPort         = 7
+	ieSrcIPv4         = 8
+	ieDstPort         = 11
+	ieDstIPv4         = 12
+	ieLastSwitched    = 21
+	ieFirstSwitched   = 22
+	ieOutOctets       = 23
+	ieOutPackets      = 24
+	ieSrcIPv6         = 27
+	ieDstIPv6         = 28
+	ieTotalOctets     = 85
+	ieTotalPackets    = 86
+	ieStartSeconds    = 150
+	ieEndSeconds      = 151
+	ieStartMillis     = 152
+	ieEndMillis       = 153
+	ieDscp            = 195
+	reverseEnterprise = 29305 // RFC 5103 bidirectional flows
+)
+
+// A templateField is a field of a NetFlow v9 or IPFIX template
+type templateField struct {
+	id         uint16
+	enterprise uint32
+	length     int // 65535 for variable length fields
+}
+
+// readUint reads a big endian unsigned integer of any length up to 8 bytes
+func readUint(b []byte) int64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return int64(n)
+}
+
+// decodeField stores the value b of field f in the record
+func (r *flowRecord) decodeField(f templateField, b []byte) {
+	if f.enterprise == reverseEnterprise {
+		switch f.id {
+		case ieOctets, ieTotalOctets:
+			r.bvolume = readUint(b)
+		case iePackets, ieTotalPackets:
+			r.bpackets = readUint(b)
+		}
+		return
+	}
+	if f.enterprise != 0 {
+		return
+	}
+	switch f.id {
+	case ieOctets, ieTotalOctets:
+		r.fvolume = readUint(b)
+	case iePackets, ieTotalPackets:
+		r.fpackets = readUint(b)
+	case ieOutOctets:
+		r.bvolume = readUint(b)
+	case ieOutPackets:
+		r.bpackets = readUint(b)
+	case ieProtocol:
+		r.set("proto", strconv.Itoa64(readUint(b)))
+	case ieTos:
+		r.set("dscp", strconv.Itoa64(readUint(b)>>2))
+	case ieDscp:
+		r.set("dscp", strconv.Itoa64(readUint(b)))
+	case ieSrcPort:
+		r.set("srcport", strconv.Itoa64(readUint(b)))
+	case ieDstPort:
+		r.set("dstport", strconv.Itoa64(readUint(b)))
+	case ieSrcIPv4, ieSrcIPv6:
+		r.set("srcip", net.IP(b).String())
+	case ieDstIPv4, ieDstIPv6:
+		r.set("dstip", net.IP(b).String())
+	case ieFirstSwitched, ieStartMillis:
+		r.start = readUint(b)
+	case ieLastSwitched, ieEndMillis:
+		r.end = readUint(b)
+	case ieStartSeconds:
+		r.start = readUint(b) * 1000
+	case ieEndSeconds:
+		r.end = readUint(b) * 1000
+	}
+}
+
+// A netflowReader reads the flow records of a file holding NetFlow v5, v9 or
+// IPFIX export packets one after another, as they were sent by the exporter.
+// The templates of v9 and IPFIX are remembered for each exporter, as later
+// packets refer to them.
+type netflowReader struct {
+	in        *bufio.Reader
+	templates map[string][]templateField
+	// Records of the current packet not yet returned
+	pending []*flowRecord
+}
+
+func newNetflowReader(in io.Reader) *netflowReader {
+	return &netflowReader{
+		in:        bufio.NewReader(in),
+		templates: map[string][]templateField{},
+	}
+}
+
+// next returns the next flow record, or os.EOF if there are no more
+func (r *netflowReader) next() (*flowRecord, os.Error) {
+	for len(r.pending) == 0 {
+		if err := r.readPacket(); err != nil {
+			return nil, err
+		}
+	}
+	rec := r.pending[0]
+	r.pending = r.pending[1:]
+	return rec, nil
+}
+
+// read reads exactly n bytes
+func (r *netflowReader) read(n int) ([]byte, os.Error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.in, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, os.NewError("truncated export packet")
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// readPacket reads the next export packet, adding its records to pending
+func (r *netflowReader) readPacket() os.Error {
+	start, err := r.in.Peek(2)
+	if err != nil {
+		if len(start) == 0 {
+			return os.EOF
+		}
+		return os.NewError("truncated export packet")
+	}
+	switch version := binary.BigEndian.Uint16(start); version {
+	case 5:
+		return r.readV5()
+	case 9:
+		return r.readV9()
+	case 10:
+		return r.readIpfix()
+	default:
+		return fmt.Errorf("unknown export version %d", version)
+	}
+	panic("unreachable")
+}
+
+// isExportVersion reports whether id is the version number of an export
+// packet we read
+func isExportVersion(id uint16) bool {
+	return id == 5 || id == 9 || id == 10
+}
+
+// readV5 reads a NetFlow v5 packet, whose records have a fixed layout
+func (r *netflowReader) readV5() os.Error {
+	hdr, err := r.read(24)
+	if err != nil {
+		return err
+	}
+	count := int(binary.BigEndian.Uint16(hdr[2:4]))
+	body, err := r.read(count * 48)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		b := body[i*48 : (i+1)*48]
+		rec := newFlowRecord()
+		rec.set("srcip", net.IP(b[0:4]).String())
+		rec.set("dstip", net.IP(b[4:8]).String())
+		rec.fpackets = readUint(b[16:20])
+		rec.fvolume = readUint(b[20:24])
+		rec.start, rec.end = readUint(b[24:28]), readUint(b[28:32])
+		rec.set("srcport", strconv.Itoa64(readUint(b[32:34])))
+		rec.set("dstport", strconv.Itoa64(readUint(b[34:36])))
+		rec.set("proto", strconv.Itoa(int(b[38])))
+		rec.set("dscp", strconv.Itoa(int(b[39]>>2)))
+		rec.finish()
+		r.pending = append(r.pending, rec)
+	}
+	return nil
+}
+
+// readV9 reads a NetFlow v9 packet. The header gives no length, and not all
+// exporters count its records alike, so the flowsets of the packet are read
+// until the version number of the next packet is found. The versions we read
+// fall among the reserved flowset IDs, so they can never be taken for the ID
+// of a flowset.
+func (r *netflowReader) readV9() os.Error {
+	hdr, err := r.read(20)
+	if err != nil {
+		return err
+	}
+	source := "9/" + strconv.Itoa64(readUint(hdr[16:20])) + "/"
+	for {
+		next, err := r.in.Peek(4)
+		if len(next) < 4 || isExportVersion(binary.BigEndian.Uint16(next)) {
+			if len(next) > 0 && len(next) < 4 {
+				return os.NewError("truncated export packet")
+			}
+			if err != nil && err != os.EOF {
+				return err
+			}
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(next[2:4]))
+		if length < 4 {
+			return os.NewError("corrupt NetFlow v9 flowset")
+		}
+		set, err := r.read(length)
+		if err != nil {
+			return err
+		}
+		if err = r.readSet(source, set, 0, 1); err != nil {
+			return err
+		}
+	}
+	panic("unreachable")
+}
+
+// readIpfix reads an IPFIX message, whose header gives its length
+func (r *netflowReader) readIpfix() os.Error {
+	hdr, err := r.read(16)
+	if err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint16(hdr[2:4]))
+	if length < 16 {
+		return os.NewError("corrupt IPFIX message")
+	}
+	body, err := r.read(length - 16)
+	if err != nil {
+		return err
+	}
+	source := "10/" + strconv.Itoa64(readUint(hdr[12:16])) + "/"
+	for len(body) >= 4 {
+		setLength := int(binary.BigEndian.Uint16(body[2:4]))
+		if setLength < 4 || setLength > len(body) {
+			return os.NewError("corrupt IPFIX set")
+		}
+		if err = r.readSet(source, body[0:setLength], 2, 3); err != nil {
+			return err
+		}
+		body = body[setLength:]
+	}
+	return nil
+}
+
+// readSet reads a set of a v9 or IPFIX packet from the given source, whose
+// template and options template sets have the given IDs.
+func (r *netflowReader) readSet(source string, set []byte,
+	templateID, optionsID uint16) os.Error {
+	id := binary.BigEndian.Uint16(set[0:2])
+	body := set[4:]
+	switch {
+	case id == templateID:
+		return r.readTemplates(source, body, templateID == 2)
+	case id == optionsID || id < 256:
+		// Options describe the exporter rather than flows
+		return nil
+	}
+	fields, exists := r.templates[source+strconv.Itoa(int(id))]
+	if !exists {
+		debugMsg("Skipping records of unknown template %d", id)
+		return nil
+	}
+	for len(body) > 0 {
+		rec := newFlowRecord()
+		n, ok := decodeRecord(rec, fields, body)
+		if !ok {
+			// What is left is padding
+			break
+		}
+		rec.finish()
+		r.pending = append(r.pending, rec)
+		body = body[n:]
+	}
+	return nil
+}
+
+// readTemplates reads a set of templates. IPFIX templates may have
+// enterprise specific fields, which carry the enterprise number.
+func (r *netflowReader) readTemplates(source string, b []byte, ipfix bool) os.Error {
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b[0:2])
+		count := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
+		fields := make([]templateField, count)
+		for i := range fields {
+			if len(b) < 4 {
+				return os.NewError("corrupt template")
+			}
+			f := &fields[i]
+			f.id = binary.BigEndian.Uint16(b[0:2])
+			f.length = int(binary.BigEndian.Uint16(b[2:4]))
+			b = b[4:]
+			if ipfix && f.id&0x8000 != 0 {
+				if len(b) < 4 {
+					return os.NewError("corrupt template")
+				}
+				f.id &= 0x7fff
+				f.enterprise = binary.BigEndian.Uint32(b[0:4])
+				b = b[4:]
+			}
+		}
+		r.templates[source+strconv.Itoa(int(id))] = fields
+	}
+	return nil
+}
+
+// decodeRecord decodes a data record laid out by the template fields from
+// the start of b, returning its length. It reports false if b is too short
+// to hold a record.
+func decodeRecord(rec *flowRecord, fields []templateField, b []byte) (int, bool) {
+	off := 0
+	for _, f := range fields {
+		length := f.length
+		if length == 65535 {
+			// Variable length, as in IPFIX
+			if off >= len(b) {
+				return 0, false
+			}
+			length, off = int(b[off]), off+1
+			if length == 255 {
+				if off+2 > len(b) {
+					return 0, false
+				}
+				length, off = int(binary.BigEndian.Uint16(b[off:off+2])), off+2
+			}
+		}
+		if length == 0 || off+length > len(b) {
+			return 0, false
+		}
+		rec.decodeField(f, b[off:off+length])
+		off += length
+	}
+	return off, off > 0
+}
+
+// readNetflow writes the flows of the named export file as CSV or ARFF, in
+// the layout of the flow schema without a label. Columns of the schema which
+// none of the records provide are reported.
+func readNetflow(fileName string) os.Error {
+	debugMsg("Opening file: %s", fileName)
+	in, err := openInput(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	suffix := "." + *netflowOutputFlag
+	if suffix != ".csv" && suffix != ".arff" {
+		return os.NewError("unknown NetFlow output format " + *netflowOutputFlag)
+	}
+	names := newOutputNames(fileName, suffix)
+	outName, err := names.name(suffix)
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(outName)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if suffix == ".arff" {
+		hdr := header{name: relationName(fileName)}
+		for i := 0; i < len(flowColumns)-1; i++ {
+			hdr.features.Push(flowColumns[i].feature())
+		}
+		if err = writeArffHeader(&hdr, out.Writer); err != nil {
+			return err
+		}
+	}
+	reader := newNetflowReader(in)
+	found := make([]bool, len(flowColumns)-1)
+	count := 0
+	for {
+		rec, err := reader.next()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return os.NewError(fileName + ": " + err.String())
+		}
+		for i, v := range rec.values {
+			if v != "?" {
+				found[i] = true
+			}
+			if suffix == ".arff" && v != "?" && flowColumns[i].Type == columnIP {
+				rec.values[i] = arffQuote(v)
+			}
+		}
+		if _, err = out.WriteString(strings.Join(rec.values, ",") + "\n"); err != nil {
+			return err
+		}
+		count++
+	}
+	var missing []string
+	for i, ok := range found {
+		if !ok {
+			missing = append(missing, flowColumns[i].Name)
+		}
+	}
+	log.Printf("Read %d flows\n", count)
+	if len(missing) > 0 {
+		log.Printf("These columns are not in the export and are missing: %s\n",
+			strings.Join(missing, ", "))
+	}
+	return out.Close()
+}
+
+// commandNetflow reads each of the NetFlow or IPFIX export files
+func commandNetflow(files []string) {
+	for _, fileName := range files {
+		errCheck(readNetflow(fileName))
+	}
+}
+
+func init() {
+	commands["netflow"] = command{
+		"read flows from NetFlow v5, v9 or IPFIX export files", commandNetflow}
+}
@@ -0,0 +1,296 @@
+/* 
+ * netflow_test.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will nbo useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+)
+
+// Export packets are in network byte order
+var nbo = binary.BigEndian
+
+// netflowV5 returns a NetFlow v5 packet holding the records
+func netflowV5(records ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	put16(buf, nbo, 5)
+	put16(buf, nbo, uint16(len(records)))
+	buf.Write(make([]byte, 20))
+	for _, r := range records {
+		buf.Write(r)
+	}
+	return buf.Bytes()
+}
+
+// v5Record returns a NetFlow v5 record of a flow from 10.0.0.1 to 10.0.0.2
+func v5Record(sport, dport uint16, proto, tos byte, packets, octets,
+	first, last uint32) []byte {
+	b := make([]byte, 48)
+	copy(b[0:8], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	nbo.PutUint32(b[16:20], packets)
+	nbo.PutUint32(b[20:24], octets)
+	nbo.PutUint32(b[24:28], first)
+	nbo.PutUint32(b[28:32], last)
+	nbo.PutUint16(b[32:34], sport)
+	nbo.PutUint16(b[34:36], dport)
+	b[38], b[39] = proto, tos
+	return b
+}
+
+// netflowSet returns a v9 flowset or IPFIX set with the given ID, padded to
+// a multiple of four bytes
+func netflowSet(id uint16, body []byte) []byte {
+	pad := (4 - len(body)%4) % 4
+	buf := new(bytes.Buffer)
+	put16(buf, nbo, id)
+	put16(buf, nbo, uint16(4+len(body)+pad))
+	buf.Write(body)
+	buf.Write(make([]byte, pad))
+	return buf.Bytes()
+}
+
+// template returns a template with the given ID, whose fields are given as
+// id, length pairs. IPFIX enterprise fields are followed by the enterprise.
+func template(id uint16, fields ...uint32) []byte {
+	buf := new(bytes.Buffer)
+	put16(buf, nbo, id)
+	count := 0
+	body := new(bytes.Buffer)
+	for i := 0; i < len(fields); i += 2 {
+		put16(body, nbo, uint16(fields[i]))
+		put16(body, nbo, uint16(fields[i+1]))
+		if fields[i]&0x8000 != 0 {
+			put32(body, nbo, fields[i+2])
+			i++
+		}
+		count++
+	}
+	put16(buf, nbo, uint16(count))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+// netflowV9 returns a NetFlow v9 packet from the given source holding the
+// flowsets. The record count of the header is left as zero, as some
+// exporters do.
+func netflowV9(source uint32, sets ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	put16(buf, nbo, 9)
+	buf.Write(make([]byte, 14))
+	put32(buf, nbo, source)
+	for _, s := range sets {
+		buf.Write(s)
+	}
+	return buf.Bytes()
+}
+
+// ipfix returns an IPFIX message holding the sets
+func ipfix(domain uint32, sets ...[]byte) []byte {
+	length := 16
+	for _, s := range sets {
+		length += len(s)
+	}
+	buf := new(bytes.Buffer)
+	put16(buf, nbo, 10)
+	put16(buf, nbo, uint16(length))
+	buf.Write(make([]byte, 8))
+	put32(buf, nbo, domain)
+	for _, s := range sets {
+		buf.Write(s)
+	}
+	return buf.Bytes()
+}
+
+// The template of the v9 flows, and a flow laid out by it
+var (
+	v9Template = template(256, ieSrcIPv4, 4, ieDstIPv4, 4, ieSrcPort, 2,
+		ieDstPort, 2, ieProtocol, 1, iePackets, 4, ieOctets, 4,
+		ieOutPackets, 4, ieOutOctets, 4, ieFirstSwitched, 4, ieLastSwitched, 4)
+	v9Flow = []byte{
+		192, 168, 1, 1, 192, 168, 1, 2, 0x04, 0xd2, 0, 53, protoUDP,
+		0, 0, 0, 2, 0, 0, 0, 120,
+		0, 0, 0, 1, 0, 0, 0, 200,
+		0, 0, 0x03, 0xe8, 0, 0, 0x07, 0xd0,
+	}
+	v9Want = map[string]string{
+		"srcip": "192.168.1.1", "dstip": "192.168.1.2",
+		"srcport": "1234", "dstport": "53", "proto": "17",
+		"total_fpackets": "2", "total_fvolume": "120", "mean_fpktl": "60",
+		"total_bpackets": "1", "total_bvolume": "200", "mean_bpktl": "200",
+		"duration": "1000000", "dscp": "?",
+	}
+)
+
+// The template of the IPFIX flows, with reverse counts and a variable
+// length field, and a flow laid out by it
+var (
+	ipfixTemplate = template(300, ieSrcIPv6, 16, ieDstIPv6, 16,
+		ieSrcPort, 2, ieDstPort, 2, ieProtocol, 1, ieDscp, 1,
+		ieTotalPackets, 8, ieTotalOctets, 8,
+		0x8000|ieTotalPackets, 8, reverseEnterprise,
+		0x8000|ieTotalOctets, 8, reverseEnterprise,
+		0x8000|1, 4, 9, // An enterprise field which is left alone
+		96, 65535, // applicationName, of variable length
+		ieStartMillis, 8, ieEndMillis, 8)
+	ipfixWant = map[string]string{
+		"srcip": "2001:db8::1", "dstip": "2001:db8::2",
+		"srcport": "443", "dstport": "50000", "proto": "6", "dscp": "46",
+		"total_fpackets": "4", "total_fvolume": "400",
+		"total_bpackets": "3", "total_bvolume": "900", "mean_bpktl": "300",
+		"duration": "2500000",
+	}
+)
+
+func ipfixFlow() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(net.ParseIP("2001:db8::1"))
+	buf.Write(net.ParseIP("2001:db8::2"))
+	put16(buf, nbo, 443)
+	put16(buf, nbo, 50000)
+	buf.Write([]byte{protoTCP, 46})
+	for _, n := range []uint32{4, 400, 3, 900} {
+		put32(buf, nbo, 0)
+		put32(buf, nbo, n)
+	}
+	put32(buf, nbo, 77)
+	buf.Write([]byte{4, 'h', 't', 't', 'p'})
+	for _, ms := range []uint32{10000, 12500} {
+		put32(buf, nbo, 0)
+		put32(buf, nbo, ms)
+	}
+	return buf.Bytes()
+}
+
+func concatPackets(packets ...[]byte) []byte {
+	var b []byte
+	for _, p := range packets {
+		b = append(b, p...)
+	}
+	return b
+}
+
+var netflowTests = []struct {
+	name   string
+	export []byte
+	want   []map[string]string
+}{
+	{"v5", netflowV5(v5Record(1234, 80, protoTCP, 40, 3, 300, 1000, 3000),
+		v5Record(5, 6, protoUDP, 0, 1, 50, 7, 7)),
+		[]map[string]string{{
+			"srcip": "10.0.0.1", "dstip": "10.0.0.2", "srcport": "1234",
+			"dstport": "80", "proto": "6", "dscp": "10",
+			"total_fpackets": "3", "total_fvolume": "300", "mean_fpktl": "100",
+			"total_bpackets": "?", "duration": "2000000",
+		}, {
+			"srcport": "5", "dstport": "6", "proto": "17", "duration": "0",
+		}}},
+	{"v9", netflowV9(1, netflowSet(0, v9Template), netflowSet(256, v9Flow)),
+		[]map[string]string{v9Want}},
+	{"v9 template from an earlier packet",
+		concatPackets(netflowV9(1, netflowSet(0, v9Template)),
+			netflowV9(1, netflowSet(256, concatPackets(v9Flow, v9Flow)))),
+		[]map[string]string{v9Want, v9Want}},
+	{"v9 template of another source",
+		concatPackets(netflowV9(1, netflowSet(0, v9Template)),
+			netflowV9(2, netflowSet(256, v9Flow))),
+		nil},
+	{"v9 options", netflowV9(1, netflowSet(1, []byte{1, 2, 3, 4}),
+		netflowSet(0, v9Template), netflowSet(256, v9Flow)),
+		[]map[string]string{v9Want}},
+	{"v9 followed by v5",
+		concatPackets(netflowV9(1, netflowSet(0, v9Template),
+			netflowSet(256, v9Flow)),
+			netflowV5(v5Record(5, 6, protoUDP, 0, 1, 50, 7, 7))),
+		[]map[string]string{v9Want, {"srcport": "5", "srcip": "10.0.0.1"}}},
+	{"ipfix", ipfix(7, netflowSet(2, ipfixTemplate),
+		netflowSet(300, ipfixFlow())),
+		[]map[string]string{ipfixWant}},
+	{"ipfix followed by v9",
+		concatPackets(ipfix(7, netflowSet(2, ipfixTemplate),
+			netflowSet(300, ipfixFlow())),
+			netflowV9(7, netflowSet(0, v9Template), netflowSet(256, v9Flow))),
+		[]map[string]string{ipfixWant, v9Want}},
+}
+
+func TestNetflowReader(t *testing.T) {
+	flow := builtinSchemas["flow"]
+	for _, tt := range netflowTests {
+		r := newNetflowReader(bytes.NewBuffer(tt.export))
+		for i, want := range tt.want {
+			rec, err := r.next()
+			if err != nil {
+				t.Errorf("%s: record %d: %s", tt.name, i, err)
+				break
+			}
+			for name, v := range want {
+				if got := rec.values[flow.hdr.attrIndex(name)]; got != v {
+					t.Errorf("%s: record %d: %s = %s, want %s", tt.name, i,
+						name, got, v)
+				}
+			}
+			if err = flow.checkRow(rec.values, false); err != nil {
+				t.Errorf("%s: record %d does not fit the flow schema: %s",
+					tt.name, i, err)
+			}
+		}
+		if rec, err := r.next(); err != os.EOF {
+			t.Errorf("%s: read %v %v after the last record, want EOF",
+				tt.name, rec, err)
+		}
+	}
+}
+
+var netflowErrorTests = []struct {
+	name   string
+	export []byte
+	err    string
+}{
+	{"unknown version", []byte{0, 7, 0, 0}, "unknown export version 7"},
+	{"truncated v5", netflowV5(v5Record(1, 2, protoTCP, 0, 1, 1, 0, 0))[0:50],
+		"truncated export packet"},
+	{"short v9 flowset", netflowV9(1, []byte{1, 0, 0, 2}),
+		"corrupt NetFlow v9 flowset"},
+	{"ipfix set overruns the message", ipfix(1, []byte{1, 0, 0, 40}),
+		"corrupt IPFIX set"},
+	{"truncated template", netflowV9(1, netflowSet(0, []byte{1, 0, 0, 2, 0, 8})),
+		"corrupt template"},
+}
+
+func TestNetflowErrors(t *testing.T) {
+	for _, tt := range netflowErrorTests {
+		r := newNetflowReader(bytes.NewBuffer(tt.export))
+		_, err := r.next()
+		if err == nil || err.String() != tt.err {
+			t.Errorf("%s: error = %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
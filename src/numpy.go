@@ -0,0 +1,308 @@
+/* 
+ * numpy.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"utf8"
+)
+
+// The space set aside for the header of a .npy file, which must be a
+// multiple of 64 and large enough for the shape of any array we write.
+const npyHeaderLen = 128
+
+// npyHeader returns the header of a version 1.0 .npy file holding an array
+// with the given element type and shape, padded to npyHeaderLen bytes.
+func npyHeader(descr string, shape string) []byte {
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, "+
+		"'shape': %s, }", descr, shape)
+	hdr := make([]byte, 10, npyHeaderLen)
+	copy(hdr, "\x93NUMPY\x01\x00")
+	binary.LittleEndian.PutUint16(hdr[8:10], npyHeaderLen-10)
+	hdr = append(hdr, dict...)
+	for len(hdr) < npyHeaderLen-1 {
+		hdr = append(hdr, ' ')
+	}
+	return append(hdr, '\n')
+}
+
+// npyShape returns the shape of an array with the given number of rows and
+// columns, written as a Python tuple. Arrays with no columns are one
+// dimensional.
+func npyShape(rows, cols int) string {
+	if cols == 0 {
+		return fmt.Sprintf("(%d,)", rows)
+	}
+	return fmt.Sprintf("(%d, %d)", rows, cols)
+}
+
+// An npyWriter streams the rows of an array to a .npy file. Since the number
+// of rows is not known until the end, the header is written last of all.
+type npyWriter struct {
+	file  *os.File
+	out   *bufio.Writer
+	descr string
+	cols  int
+	rows  int
+	buf   [8]byte
+}
+
+// createNpy creates a .npy file for an array of the given element type with
+// the given number of columns, or a one dimensional array if cols is zero.
+func createNpy(fileName string, descr string, cols int) (*npyWriter, os.Error) {
+	file, err := createFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	w := &npyWriter{file: file, out: bufio.NewWriter(file), descr: descr,
+		cols: cols}
+	// Hold the place of the header until the shape is known
+	_, err = w.out.Write(npyHeader(descr, npyShape(0, cols)))
+	return w, err
+}
+
+// writeFloat writes the next element of a '<f8' array
+func (w *npyWriter) writeFloat(x float64) os.Error {
+	binary.LittleEndian.PutUint64(w.buf[:], math.Float64bits(x))
+	_, err := w.out.Write(w.buf[:])
+	return err
+}
+
+// writeInt writes the next element of a '<i8' array
+func (w *npyWriter) writeInt(x int64) os.Error {
+	binary.LittleEndian.PutUint64(w.buf[:], uint64(x))
+	_, err := w.out.Write(w.buf[:])
+	return err
+}
+
+// close fills in the header of the file and closes it
+func (w *npyWriter) close() os.Error {
+	defer closeFile(w.file)
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+	_, err := w.file.WriteAt(npyHeader(w.descr, npyShape(w.rows, w.cols)), 0)
+	return err
+}
+
+// npyStrings returns a .npy file holding the given strings as a one
+// dimensional array of fixed width unicode strings.
+func npyStrings(strs []string) []byte {
+	width := 1
+	for _, s := range strs {
+		if n := utf8.RuneCountInString(s); n > width {
+			width = n
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(npyHeader(fmt.Sprintf("<U%d", width), npyShape(len(strs), 0)))
+	var char [4]byte
+	for _, s := range strs {
+		n := 0
+		for _, c := range s {
+			binary.LittleEndian.PutUint32(char[:], uint32(c))
+			buf.Write(char[:])
+			n++
+		}
+		buf.Write(make([]byte, 4*(width-n)))
+	}
+	return buf.Bytes()
+}
+
+// numpyConverter writes the features of the data set as a float64 matrix in a
+// .features.npy file, and the labels as int64 codes in a .labels.npy file.
+// Nominal and string features are written as codes, dates in seconds since
+// the epoch and missing values as NaN. Numeric labels are written as they
+// are, as float64. Once done, both arrays are bundled into a .npz file along
+// with the feature names and label names, each label name being found at the
+// index of its code. Instances are written as they are read, so the data set
+// never needs to fit in memory.
+type numpyConverter struct {
+	hdr      *header
+	class    int
+	columns  []int
+	codes    map[int]*codeMap
+	labels   *codeMap
+	features *npyWriter
+	classes  *npyWriter
+	// The names of the .features.npy, .labels.npy and .npz files
+	featuresName, labelsName, npzName string
+	// The number of instances left out for want of a label
+	skipped int
+}
+
+func (c *numpyConverter) open(hdr *header, names *outputNames) os.Error {
+	var err os.Error
+	if c.class, err = classIndex(hdr); err != nil {
+		return err
+	}
+	exclude, err := excludedColumns(hdr)
+	if err != nil {
+		return err
+	}
+	if c.featuresName, err = names.name(".features.npy"); err != nil {
+		return err
+	}
+	if c.labelsName, err = names.name(".labels.npy"); err != nil {
+		return err
+	}
+	if c.npzName, err = names.name(".npz"); err != nil {
+		return err
+	}
+	c.hdr = hdr
+	c.codes = map[int]*codeMap{}
+	for i := 0; i < hdr.features.Len(); i++ {
+		if i == c.class || exclude[i] {
+			continue
+		}
+		c.columns = append(c.columns, i)
+		if f := hdr.attr(i); f.datatype == typeString {
+			c.codes[i] = newCodeMap(nil)
+		}
+	}
+	c.features, err = createNpy(c.featuresName, "<f8", len(c.columns))
+	if err != nil {
+		return err
+	}
+	descr := "<i8"
+	if hdr.attr(c.class).isNumeric() {
+		descr = "<f8"
+	} else {
+		c.labels = newCodeMap(hdr.attr(c.class).nominal)
+	}
+	c.classes, err = createNpy(c.labelsName, descr, 0)
+	return err
+}
+
+func (c *numpyConverter) write(inst *instance) os.Error {
+	label := inst.values[c.class]
+	if label.missing {
+		c.skipped++
+		return nil
+	}
+	for _, i := range c.columns {
+		v := inst.values[i]
+		x := v.num
+		if v.missing {
+			x = math.NaN()
+		} else if codes, exists := c.codes[i]; exists {
+			x = float64(codes.code(v.str))
+		}
+		if err := c.features.writeFloat(x); err != nil {
+			return err
+		}
+	}
+	c.features.rows++
+	c.classes.rows++
+	if c.labels == nil {
+		return c.classes.writeFloat(label.num)
+	}
+	return c.classes.writeInt(int64(c.labels.code(label.str)))
+}
+
+func (c *numpyConverter) close() os.Error {
+	if c.skipped > 0 {
+		log.Printf("Skipped %d instances with no class label\n", c.skipped)
+	}
+	if err := c.features.close(); err != nil {
+		return err
+	}
+	if err := c.classes.close(); err != nil {
+		return err
+	}
+	npzFile, err := createFile(c.npzName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(npzFile)
+	npz := zip.NewWriter(npzFile)
+	if err = addFileToZip(npz, "features.npy", c.featuresName); err != nil {
+		return err
+	}
+	if err = addFileToZip(npz, "labels.npy", c.labelsName); err != nil {
+		return err
+	}
+	names := make([]string, len(c.columns))
+	for j, i := range c.columns {
+		names[j] = c.hdr.attr(i).name
+	}
+	if err = addBytesToZip(npz, "feature_names.npy", npyStrings(names)); err != nil {
+		return err
+	}
+	if c.labels != nil {
+		err = addBytesToZip(npz, "label_names.npy", npyStrings(c.labels.values))
+		if err != nil {
+			return err
+		}
+	}
+	return npz.Close()
+}
+
+// addFileToZip copies the named file into the zip archive
+func addFileToZip(archive *zip.Writer, name string, fileName string) os.Error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// addBytesToZip adds a file holding data to the zip archive
+func addBytesToZip(archive *zip.Writer, name string, data []byte) os.Error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, bytes.NewBuffer(data))
+	return err
+}
+
+func init() {
+	registerFormat(&format{
+		name:   "numpy",
+		desc:   "NumPy (.features.npy, .labels.npy, .npz)",
+		suffix: ".npz",
+		newConverter: func() converter {
+			return new(numpyConverter)
+		},
+	})
+}
@@ -0,0 +1,165 @@
+/* 
+ * numpy_test.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// npyFile returns the header numpy.save writes for an array with the given
+// element type and shape, followed by data.
+func npyFile(dict string, data string) string {
+	return "\x93NUMPY\x01\x00\x76\x00" + dict +
+		strings.Repeat(" ", 117-len(dict)) + "\n" + data
+}
+
+var npyShapeTests = []struct {
+	rows, cols int
+	shape      string
+}{
+	{0, 0, "(0,)"},
+	{3, 0, "(3,)"},
+	{2, 3, "(2, 3)"},
+	{0, 4, "(0, 4)"},
+}
+
+func TestNpyShape(t *testing.T) {
+	for _, tt := range npyShapeTests {
+		if shape := npyShape(tt.rows, tt.cols); shape != tt.shape {
+			t.Errorf("npyShape(%d, %d) = %s, want %s",
+				tt.rows, tt.cols, shape, tt.shape)
+		}
+	}
+}
+
+func TestNpyHeader(t *testing.T) {
+	hdr := npyHeader("<f8", "(2, 3)")
+	want := npyFile("{'descr': '<f8', 'fortran_order': False, "+
+		"'shape': (2, 3), }", "")
+	if string(hdr) != want {
+		t.Errorf("npyHeader = %q, want %q", hdr, want)
+	}
+	if len(hdr)%64 != 0 {
+		t.Errorf("npyHeader is %d bytes long, not a multiple of 64", len(hdr))
+	}
+}
+
+func TestNpyStrings(t *testing.T) {
+	// numpy.save("s.npy", numpy.array(["ab", "ĉ"]))
+	want := npyFile("{'descr': '<U2', 'fortran_order': False, "+
+		"'shape': (2,), }", "a\x00\x00\x00b\x00\x00\x00\x09\x01\x00\x00"+
+		"\x00\x00\x00\x00")
+	if got := string(npyStrings([]string{"ab", "ĉ"})); got != want {
+		t.Errorf("npyStrings = %q, want %q", got, want)
+	}
+}
+
+const numpyData = `@relation t
+@attribute a numeric
+@attribute b {x,y}
+@attribute c string
+@attribute class {p,q}
+@data
+1.5,y,foo,q
+?,x,bar,p
+2,y,foo,?
+`
+
+func TestNumpyConverter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adp")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	defer os.RemoveAll(dir)
+	reader, err := newArffReader(bufio.NewReader(strings.NewReader(numpyData)))
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	fileName := filepath.Join(dir, "t")
+	if err = convert(reader, fileName, []*format{findFormat("numpy")}); err != nil {
+		t.Fatal(err.String())
+	}
+
+	// The labels of the two labeled instances, coded in declaration order
+	labels, err := ioutil.ReadFile(fileName + ".labels.npy")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	want := npyFile("{'descr': '<i8', 'fortran_order': False, "+
+		"'shape': (2,), }", "\x01\x00\x00\x00\x00\x00\x00\x00"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00")
+	if string(labels) != want {
+		t.Errorf(".labels.npy = %q, want %q", labels, want)
+	}
+
+	// A 2x3 matrix, with the missing value as NaN
+	features, err := ioutil.ReadFile(fileName + ".features.npy")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	hdr := npyFile("{'descr': '<f8', 'fortran_order': False, "+
+		"'shape': (2, 3), }", "")
+	if len(features) != len(hdr)+6*8 || string(features[0:len(hdr)]) != hdr {
+		t.Fatalf(".features.npy = %q", features)
+	}
+	values := []float64{1.5, 1, 0, math.NaN(), 0, 1}
+	for i, x := range values {
+		var y float64
+		binary.Read(bytes.NewBuffer(features[len(hdr)+8*i:]),
+			binary.LittleEndian, &y)
+		if y != x && !(math.IsNaN(x) && math.IsNaN(y)) {
+			t.Errorf("feature %d is %v, want %v", i, y, x)
+		}
+	}
+
+	npz, err := zip.OpenReader(fileName + ".npz")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	defer npz.Close()
+	names := []string{"features.npy", "labels.npy", "feature_names.npy",
+		"label_names.npy"}
+	if len(npz.File) != len(names) {
+		t.Fatalf(".npz holds %d files, want %d", len(npz.File), len(names))
+	}
+	for i, f := range npz.File {
+		if f.Name != names[i] {
+			t.Errorf(".npz file %d is %s, want %s", i, f.Name, names[i])
+		}
+	}
+}
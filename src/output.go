@@ -0,0 +1,139 @@
+/* 
+ * output.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options naming the files written by every operation
+var (
+	outputFlag = flag.String("o", "",
+		"path of the main output file, or - for standard output")
+	outdirFlag = flag.String("outdir", "",
+		"directory to write output files to, instead of next to the input")
+	prefixFlag = flag.String("prefix", "",
+		"path to name output files after, instead of the input")
+	templateFlag = flag.String("template", "{base}{suffix}",
+		"template naming output files from {base}, {stem} and {suffix}")
+	forceFlag = flag.Bool("force", false, "overwrite existing output files")
+)
+
+// The name given in place of a file to read from standard input or write to
+// standard output
+const stdioName = "-"
+
+// Set once standard output has been handed out for writing
+var stdoutTaken bool
+
+// takeStdout returns standard output for writing. It may only be taken once,
+// since two outputs written to it would be interleaved.
+func takeStdout() (*os.File, os.Error) {
+	if stdoutTaken {
+		return nil, os.NewError("only one output can be written to standard output")
+	}
+	stdoutTaken = true
+	debugMsg("Writing to standard output")
+	return os.Stdout, nil
+}
+
+// checkOutputFlags checks that the options naming output files make sense
+func checkOutputFlags() os.Error {
+	if strings.Index(*templateFlag, "{suffix}") < 0 {
+		return os.NewError("-template must contain {suffix}, " +
+			"or every output would have the same name")
+	}
+	return checkCompressFlag()
+}
+
+// checkOutputCount checks that -o and -prefix, which each name the outputs
+// of a single file, are not given when several files are read or several
+// formats are written for each
+func checkOutputCount(inputs, formats int) os.Error {
+	if *outputFlag != "" && (inputs > 1 || formats > 1) {
+		return os.NewError("-o names a single output, so it cannot be " +
+			"given with more than one input or format")
+	}
+	if *prefixFlag != "" && inputs > 1 {
+		return os.NewError("-prefix names the outputs of a single input, " +
+			"so it cannot be given with more than one")
+	}
+	return nil
+}
+
+// outputNames decides the names of the files written by an operation. Each
+// output has a suffix, such as ".train", which is filled into -template along
+// with the base name of the input, or of -prefix if it is given. By default
+// outputs are written next to the input, or to -outdir if it is given.
+//
+// The primary output of the operation is written to -o if it is given. When
+// the input is standard input, the primary output is otherwise written to
+// standard output, and any other outputs must be named by -prefix.
+type outputNames struct {
+	input   string
+	primary string
+}
+
+// newOutputNames returns the names of the outputs for the named input, of
+// which the output with the suffix primary is the one to stream. Outputs are
+// named after the data, not the compressed file holding it.
+func newOutputNames(input, primary string) *outputNames {
+	return &outputNames{stripCompression(input), primary}
+}
+
+// name returns the name of the output with the given suffix. Outputs of
+// standard input other than the primary one are refused unless -prefix is
+// given, rather than left in the current directory under a made up name.
+func (o *outputNames) name(suffix string) (string, os.Error) {
+	if suffix == o.primary {
+		if *outputFlag != "" {
+			return *outputFlag, nil
+		}
+		if o.input == stdioName {
+			return stdioName, nil
+		}
+	}
+	dir, base := filepath.Split(o.input)
+	if *prefixFlag != "" {
+		dir, base = filepath.Split(*prefixFlag)
+	} else if o.input == stdioName {
+		return "", os.NewError("the " + suffix + " output has no name when " +
+			"reading standard input; give -prefix to name it")
+	}
+	if *outdirFlag != "" {
+		dir = *outdirFlag
+	}
+	stem := base[0 : len(base)-len(filepath.Ext(base))]
+	name := strings.Replace(*templateFlag, "{base}", base, -1)
+	name = strings.Replace(name, "{stem}", stem, -1)
+	name = strings.Replace(name, "{suffix}", suffix, -1)
+	return filepath.Join(dir, name), nil
+}
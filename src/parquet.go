@@ -0,0 +1,516 @@
+/* 
+ * parquet.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flag"
+	"math"
+	"os"
+)
+
+// Parquet physical types, converted types, encodings and codecs, as defined
+// by parquet.thrift.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetNoConversion    = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+
+	parquetUncompressed = 0
+	parquetGzip         = 2
+
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+
+	parquetOptional = 1
+)
+
+// Type codes used by the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// A thriftWriter encodes structures with the Thrift compact protocol, as used
+// for the metadata of a Parquet file. Fields must be written in order of
+// their ids, and nested structures begun and ended in turn.
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastIDs []int
+	lastID  int
+}
+
+func (t *thriftWriter) uvarint(x uint64) {
+	for x >= 0x80 {
+		t.buf.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	t.buf.WriteByte(byte(x))
+}
+
+func (t *thriftWriter) varint(x int64) {
+	t.uvarint(uint64((x << 1) ^ (x >> 63)))
+}
+
+func (t *thriftWriter) field(id int, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta<<4) | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int, x int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(x))
+}
+
+func (t *thriftWriter) i64(id int, x int64) {
+	t.field(id, thriftI64)
+	t.varint(x)
+}
+
+func (t *thriftWriter) binary(id int, s string) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// list writes the header of a list field holding n elements of type typ,
+// which must then be written without field headers.
+func (t *thriftWriter) list(id int, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n<<4) | typ)
+	} else {
+		t.buf.WriteByte(0xf0 | typ)
+		t.uvarint(uint64(n))
+	}
+}
+
+// begin starts a nested structure, either as the field with the given id or,
+// if id is zero, as an element of a list.
+func (t *thriftWriter) begin(id int) {
+	if id != 0 {
+		t.field(id, thriftStruct)
+	}
+	t.lastIDs = append(t.lastIDs, t.lastID)
+	t.lastID = 0
+}
+
+// end finishes the innermost structure
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.lastID = t.lastIDs[len(t.lastIDs)-1]
+	t.lastIDs = t.lastIDs[0 : len(t.lastIDs)-1]
+}
+
+// rleRuns encodes levels with the RLE half of Parquet's RLE/bit-packing
+// hybrid encoding, using the given bit width.
+func rleRuns(levels []int, width int) []byte {
+	var t thriftWriter
+	for i := 0; i < len(levels); {
+		n := 1
+		for i+n < len(levels) && levels[i+n] == levels[i] {
+			n++
+		}
+		t.uvarint(uint64(n) << 1)
+		for b := 0; b < (width+7)/8; b++ {
+			t.buf.WriteByte(byte(levels[i] >> uint(8*b)))
+		}
+		i += n
+	}
+	return t.buf.Bytes()
+}
+
+// A parquetColumn buffers the values of one column for the current row group
+type parquetColumn struct {
+	feature   int
+	name      string
+	ptype     int32
+	converted int32
+	// The dictionary of a dictionary encoded column, or nil
+	dict *codeMap
+	// The definition level of each row, and the values which are present,
+	// either plain encoded or as dictionary indexes.
+	defs    []int
+	plain   bytes.Buffer
+	indexes []int
+}
+
+// add buffers the value of the column for the next row
+func (c *parquetColumn) add(v value) {
+	if v.missing {
+		c.defs = append(c.defs, 0)
+		return
+	}
+	c.defs = append(c.defs, 1)
+	var buf [8]byte
+	switch {
+	case c.dict != nil:
+		c.indexes = append(c.indexes, c.dict.code(v.str))
+	case c.ptype == parquetByteArray:
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(len(v.str)))
+		c.plain.Write(buf[0:4])
+		c.plain.WriteString(v.str)
+	case c.ptype == parquetDouble:
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v.num))
+		c.plain.Write(buf[:])
+	case c.converted == parquetTimestampMillis:
+		binary.LittleEndian.PutUint64(buf[:], uint64(int64(v.num*1000)))
+		c.plain.Write(buf[:])
+	default:
+		binary.LittleEndian.PutUint64(buf[:], uint64(int64(v.num)))
+		c.plain.Write(buf[:])
+	}
+}
+
+// A parquetChunk records where a column chunk was written, for the footer
+type parquetChunk struct {
+	offset, dictOffset, dataOffset int64
+	uncompressed, compressed       int64
+	values                         int64
+}
+
+var (
+	parquetRowsFlag = flag.Int("parquet-rows", 100000,
+		"number of rows in each Parquet row group")
+	parquetCompressionFlag = flag.String("parquet-compression", "gzip",
+		"compression of Parquet pages: none or gzip")
+)
+
+// parquetConverter writes the data set as an Apache Parquet file. Integer and
+// date attributes become int64 columns, other numeric attributes double
+// columns, nominal attributes dictionary encoded strings and string
+// attributes plain strings. The label is always written as a string column.
+// Rows are buffered in memory one row group at a time.
+type parquetConverter struct {
+	hdr       *header
+	columns   []*parquetColumn
+	codec     int32
+	file      *os.File
+	out       *bufio.Writer
+	offset    int64
+	rows      int
+	totalRows int64
+	// The chunks of every row group written so far, and their row counts
+	groups    [][]parquetChunk
+	groupRows []int
+}
+
+func (c *parquetConverter) open(hdr *header, names *outputNames) os.Error {
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	exclude, err := excludedColumns(hdr)
+	if err != nil {
+		return err
+	}
+	switch *parquetCompressionFlag {
+	case "none":
+		c.codec = parquetUncompressed
+	case "gzip":
+		c.codec = parquetGzip
+	default:
+		return os.NewError("unknown Parquet compression " +
+			*parquetCompressionFlag)
+	}
+	if *parquetRowsFlag < 1 {
+		return os.NewError("Parquet row groups must hold at least one row")
+	}
+	c.hdr = hdr
+	for i := 0; i < hdr.features.Len(); i++ {
+		if exclude[i] && i != class {
+			continue
+		}
+		f := hdr.attr(i)
+		col := &parquetColumn{feature: i, name: f.name,
+			ptype: parquetByteArray, converted: parquetUTF8}
+		switch {
+		case f.datatype == typeNominal:
+			col.dict = newCodeMap(f.nominal)
+		case i == class || f.datatype == typeString:
+		case f.datatype == typeInteger:
+			col.ptype, col.converted = parquetInt64, parquetNoConversion
+		case f.datatype == typeDate:
+			col.ptype, col.converted = parquetInt64, parquetTimestampMillis
+		default:
+			col.ptype, col.converted = parquetDouble, parquetNoConversion
+		}
+		c.columns = append(c.columns, col)
+	}
+	name, err := names.name(".parquet")
+	if err != nil {
+		return err
+	}
+	if c.file, err = createFile(name); err != nil {
+		return err
+	}
+	c.out = bufio.NewWriter(c.file)
+	return c.emit([]byte("PAR1"))
+}
+
+// emit writes b to the file, keeping track of the offset
+func (c *parquetConverter) emit(b []byte) os.Error {
+	n, err := c.out.Write(b)
+	c.offset += int64(n)
+	return err
+}
+
+func (c *parquetConverter) write(inst *instance) os.Error {
+	for _, col := range c.columns {
+		col.add(inst.values[col.feature])
+	}
+	c.rows++
+	if c.rows == *parquetRowsFlag {
+		return c.flushRowGroup()
+	}
+	return nil
+}
+
+// writePage compresses and writes a page, along with its header. The header
+// fields particular to the type of page are written by pageHeader.
+func (c *parquetConverter) writePage(chunk *parquetChunk, pageType int32,
+	page []byte, pageHeader func(t *thriftWriter)) os.Error {
+	data := page
+	if c.codec == parquetGzip {
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriter(&buf)
+		if err != nil {
+			return err
+		}
+		zw.Write(page)
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	var t thriftWriter
+	t.i32(1, pageType)
+	t.i32(2, int32(len(page)))
+	t.i32(3, int32(len(data)))
+	pageHeader(&t)
+	t.buf.WriteByte(0)
+	chunk.uncompressed += int64(t.buf.Len() + len(page))
+	chunk.compressed += int64(t.buf.Len() + len(data))
+	if err := c.emit(t.buf.Bytes()); err != nil {
+		return err
+	}
+	return c.emit(data)
+}
+
+// writeChunk writes the buffered values of a column as a column chunk,
+// preceded by a dictionary page if the column is dictionary encoded.
+func (c *parquetConverter) writeChunk(col *parquetColumn) (parquetChunk, os.Error) {
+	chunk := parquetChunk{offset: c.offset, dictOffset: -1,
+		values: int64(len(col.defs))}
+	var page bytes.Buffer
+	var lenBuf [4]byte
+	encoding := int32(parquetPlain)
+	if col.dict != nil {
+		for _, s := range col.dict.values {
+			binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(s)))
+			page.Write(lenBuf[:])
+			page.WriteString(s)
+		}
+		chunk.dictOffset = c.offset
+		entries := int32(len(col.dict.values))
+		err := c.writePage(&chunk, parquetDictionaryPage, page.Bytes(),
+			func(t *thriftWriter) {
+				t.begin(7)
+				t.i32(1, entries)
+				t.i32(2, parquetPlainDictionary)
+				t.end()
+			})
+		if err != nil {
+			return chunk, err
+		}
+		page.Reset()
+		encoding = parquetPlainDictionary
+	}
+	chunk.dataOffset = c.offset
+	defs := rleRuns(col.defs, 1)
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(defs)))
+	page.Write(lenBuf[:])
+	page.Write(defs)
+	if col.dict != nil {
+		width := 1
+		for 1<<uint(width) < len(col.dict.values) {
+			width++
+		}
+		page.WriteByte(byte(width))
+		page.Write(rleRuns(col.indexes, width))
+	} else {
+		page.Write(col.plain.Bytes())
+	}
+	values := int32(len(col.defs))
+	err := c.writePage(&chunk, parquetDataPage, page.Bytes(),
+		func(t *thriftWriter) {
+			t.begin(5)
+			t.i32(1, values)
+			t.i32(2, encoding)
+			t.i32(3, parquetRLE)
+			t.i32(4, parquetRLE)
+			t.end()
+		})
+	return chunk, err
+}
+
+// flushRowGroup writes the buffered rows as a row group
+func (c *parquetConverter) flushRowGroup() os.Error {
+	if c.rows == 0 {
+		return nil
+	}
+	chunks := make([]parquetChunk, len(c.columns))
+	for i, col := range c.columns {
+		var err os.Error
+		if chunks[i], err = c.writeChunk(col); err != nil {
+			return err
+		}
+		col.defs = col.defs[0:0]
+		col.indexes = col.indexes[0:0]
+		col.plain.Reset()
+	}
+	c.groups = append(c.groups, chunks)
+	c.groupRows = append(c.groupRows, c.rows)
+	c.totalRows += int64(c.rows)
+	c.rows = 0
+	return nil
+}
+
+// writeFooter writes the file metadata which ends a Parquet file
+func (c *parquetConverter) writeFooter() os.Error {
+	var t thriftWriter
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(c.columns)+1)
+	t.begin(0)
+	t.binary(4, c.hdr.name)
+	t.i32(5, int32(len(c.columns)))
+	t.end()
+	for _, col := range c.columns {
+		t.begin(0)
+		t.i32(1, col.ptype)
+		t.i32(3, parquetOptional)
+		t.binary(4, col.name)
+		if col.converted != parquetNoConversion {
+			t.i32(6, col.converted)
+		}
+		t.end()
+	}
+	t.i64(3, c.totalRows)
+	t.list(4, thriftStruct, len(c.groups))
+	for g, chunks := range c.groups {
+		var size int64
+		for _, chunk := range chunks {
+			size += chunk.uncompressed
+		}
+		t.begin(0)
+		t.list(1, thriftStruct, len(chunks))
+		for i, chunk := range chunks {
+			col := c.columns[i]
+			t.begin(0)
+			t.i64(2, chunk.offset)
+			t.begin(3)
+			t.i32(1, col.ptype)
+			if col.dict != nil {
+				t.list(2, thriftI32, 3)
+				t.varint(parquetPlainDictionary)
+			} else {
+				t.list(2, thriftI32, 2)
+			}
+			t.varint(parquetPlain)
+			t.varint(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.uvarint(uint64(len(col.name)))
+			t.buf.WriteString(col.name)
+			t.i32(4, c.codec)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressed)
+			t.i64(7, chunk.compressed)
+			t.i64(9, chunk.dataOffset)
+			if chunk.dictOffset >= 0 {
+				t.i64(11, chunk.dictOffset)
+			}
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, int64(c.groupRows[g]))
+		t.end()
+	}
+	t.binary(6, "adp")
+	t.buf.WriteByte(0)
+	if err := c.emit(t.buf.Bytes()); err != nil {
+		return err
+	}
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(t.buf.Len()))
+	if err := c.emit(lenBuf[:]); err != nil {
+		return err
+	}
+	return c.emit([]byte("PAR1"))
+}
+
+func (c *parquetConverter) close() os.Error {
+	defer closeFile(c.file)
+	if err := c.flushRowGroup(); err != nil {
+		return err
+	}
+	if err := c.writeFooter(); err != nil {
+		return err
+	}
+	return c.out.Flush()
+}
+
+func init() {
+	registerFormat(&format{
+		name:   "parquet",
+		desc:   "Apache Parquet (.parquet)",
+		suffix: ".parquet",
+		newConverter: func() converter {
+			return new(parquetConverter)
+		},
+	})
+}
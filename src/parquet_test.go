@@ -0,0 +1,299 @@
+/* 
+ * parquet_test.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Encodings taken from the Thrift compact protocol specification
+var thriftWriterTests = []struct {
+	desc  string
+	write func(t *thriftWriter)
+	bytes string
+}{
+	{"i32 field 1", func(t *thriftWriter) { t.i32(1, 0) }, "\x15\x00"},
+	{"i32 -1", func(t *thriftWriter) { t.i32(1, -1) }, "\x15\x01"},
+	{"i32 64", func(t *thriftWriter) { t.i32(1, 64) }, "\x15\x80\x01"},
+	{"i64 150", func(t *thriftWriter) { t.i64(2, 150) }, "\x26\xac\x02"},
+	{"field ids in steps", func(t *thriftWriter) {
+		t.i32(1, 1)
+		t.i32(3, 1)
+	}, "\x15\x02\x25\x02"},
+	{"long field delta", func(t *thriftWriter) { t.i32(17, 1) }, "\x05\x22\x02"},
+	{"binary", func(t *thriftWriter) { t.binary(4, "abc") }, "\x48\x03abc"},
+	{"short list", func(t *thriftWriter) { t.list(2, thriftI32, 3) }, "\x29\x35"},
+	{"long list", func(t *thriftWriter) { t.list(2, thriftStruct, 20) },
+		"\x29\xfc\x14"},
+	{"nested struct", func(t *thriftWriter) {
+		t.i32(1, 1)
+		t.begin(5)
+		t.i32(1, 2)
+		t.end()
+		t.i32(6, 3)
+	}, "\x15\x02\x4c\x15\x04\x00\x15\x06"},
+	{"struct in a list", func(t *thriftWriter) {
+		t.list(1, thriftStruct, 1)
+		t.begin(0)
+		t.i32(2, 1)
+		t.end()
+		t.i32(2, 1)
+	}, "\x19\x1c\x25\x02\x00\x15\x02"},
+}
+
+func TestThriftWriter(t *testing.T) {
+	for _, tt := range thriftWriterTests {
+		var w thriftWriter
+		tt.write(&w)
+		if got := w.buf.String(); got != tt.bytes {
+			t.Errorf("%s: wrote %q, want %q", tt.desc, got, tt.bytes)
+		}
+	}
+}
+
+var rleRunsTests = []struct {
+	levels []int
+	width  int
+	bytes  string
+}{
+	{[]int{1, 1, 1}, 1, "\x06\x01"},
+	{[]int{1, 0, 0, 1}, 1, "\x02\x01\x04\x00\x02\x01"},
+	{[]int{3, 3, 2}, 2, "\x04\x03\x02\x02"},
+	{[]int{300, 300}, 9, "\x04\x2c\x01"},
+	{make([]int, 64), 1, "\x80\x01\x00"},
+	{nil, 1, ""},
+}
+
+func TestRleRuns(t *testing.T) {
+	for _, tt := range rleRunsTests {
+		if got := string(rleRuns(tt.levels, tt.width)); got != tt.bytes {
+			t.Errorf("rleRuns(%v, %d) = %q, want %q",
+				tt.levels, tt.width, got, tt.bytes)
+		}
+	}
+}
+
+// A thriftReader decodes the Thrift compact protocol, independently of
+// thriftWriter, into maps from field ids to values.
+type thriftReader struct {
+	b []byte
+	p int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	var x uint64
+	for shift := uint(0); ; shift += 7 {
+		c := r.b[r.p]
+		r.p++
+		x |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return x
+		}
+	}
+	panic("unreachable")
+}
+
+func (r *thriftReader) varint() int64 {
+	x := r.uvarint()
+	return int64(x>>1) ^ -int64(x&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		r.p += n
+		return string(r.b[r.p-n : r.p])
+	case thriftList:
+		h := r.b[r.p]
+		r.p++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(h & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic("unexpected Thrift type")
+}
+
+func (r *thriftReader) readStruct() map[int]interface{} {
+	s := map[int]interface{}{}
+	id := 0
+	for {
+		h := r.b[r.p]
+		r.p++
+		if h == 0 {
+			return s
+		}
+		if h>>4 != 0 {
+			id += int(h >> 4)
+		} else {
+			id = int(r.varint())
+		}
+		s[id] = r.value(h & 0x0f)
+	}
+	panic("unreachable")
+}
+
+const parquetData = `@relation flows
+@attribute a numeric
+@attribute n integer
+@attribute b {x,y}
+@attribute class {p,q}
+@data
+1.5,3,y,q
+?,4,x,p
+2,5,y,q
+`
+
+func TestParquetConverter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adp")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	defer os.RemoveAll(dir)
+	reader, err := newArffReader(bufio.NewReader(strings.NewReader(parquetData)))
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	fileName := filepath.Join(dir, "t")
+	defer func(rows int, compression string) {
+		*parquetRowsFlag, *parquetCompressionFlag = rows, compression
+	}(*parquetRowsFlag, *parquetCompressionFlag)
+	*parquetRowsFlag, *parquetCompressionFlag = 2, "none"
+	if err = convert(reader, fileName, []*format{findFormat("parquet")}); err != nil {
+		t.Fatal(err.String())
+	}
+	file, err := ioutil.ReadFile(fileName + ".parquet")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+
+	// PAR1, the pages, the footer, its length and PAR1 again
+	n := len(file)
+	if string(file[0:4]) != "PAR1" || string(file[n-4:]) != "PAR1" {
+		t.Fatalf("missing magic numbers in %q", file)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[n-8 : n-4]))
+	footer := &thriftReader{file[n-8-footerLen : n-8], 0}
+	meta := footer.readStruct()
+	if footer.p != footerLen {
+		t.Errorf("footer is %d bytes long, but read %d", footerLen, footer.p)
+	}
+	if meta[1] != int64(1) || meta[3] != int64(3) || meta[6] != "adp" {
+		t.Errorf("file metadata %v", meta)
+	}
+
+	// The root of the schema and one optional column per attribute
+	schema := meta[2].([]interface{})
+	columns := []struct {
+		name      string
+		ptype     int64
+		converted interface{}
+	}{
+		{"flows", 0, nil},
+		{"a", parquetDouble, nil},
+		{"n", parquetInt64, nil},
+		{"b", parquetByteArray, int64(parquetUTF8)},
+		{"class", parquetByteArray, int64(parquetUTF8)},
+	}
+	if len(schema) != len(columns) {
+		t.Fatalf("schema %v", schema)
+	}
+	for i, col := range columns {
+		s := schema[i].(map[int]interface{})
+		if s[4] != col.name || s[6] != col.converted {
+			t.Errorf("schema element %d: %v", i, s)
+		}
+		if i == 0 {
+			if s[5] != int64(4) {
+				t.Errorf("schema root has %v children, want 4", s[5])
+			}
+		} else if s[1] != col.ptype || s[3] != int64(parquetOptional) {
+			t.Errorf("schema element %d: %v", i, s)
+		}
+	}
+
+	// Two row groups, of two rows and one, whose column chunks point at
+	// their pages
+	groups := meta[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("%d row groups, want 2", len(groups))
+	}
+	for g, rows := range []int64{2, 1} {
+		group := groups[g].(map[int]interface{})
+		if group[3] != rows {
+			t.Errorf("row group %d has %v rows, want %d", g, group[3], rows)
+		}
+		for i, c := range group[1].([]interface{}) {
+			chunk := c.(map[int]interface{})[3].(map[int]interface{})
+			if chunk[5] != rows || chunk[4] != int64(parquetUncompressed) {
+				t.Errorf("row group %d, column %d: %v", g, i, chunk)
+			}
+			offset := chunk[9].(int64)
+			if dict, exists := chunk[11]; exists {
+				page := &thriftReader{file, int(dict.(int64))}
+				if h := page.readStruct(); h[1] != int64(parquetDictionaryPage) {
+					t.Errorf("row group %d, column %d: dictionary page %v",
+						g, i, h)
+				}
+			}
+			page := &thriftReader{file, int(offset)}
+			h := page.readStruct()
+			if h[1] != int64(parquetDataPage) {
+				t.Errorf("row group %d, column %d: data page %v", g, i, h)
+			}
+			if g == 0 && i == 0 {
+				// Definition levels 1, 0 as RLE runs and the one double
+				var x [8]byte
+				binary.LittleEndian.PutUint64(x[:], math.Float64bits(1.5))
+				want := "\x04\x00\x00\x00\x02\x01\x02\x00" + string(x[:])
+				size := int(h[2].(int64))
+				if got := string(file[page.p : page.p+size]); got != want {
+					t.Errorf("first page of a is %q, want %q", got, want)
+				}
+			}
+		}
+	}
+}
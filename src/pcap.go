@@ -0,0 +1,425 @@
+/* 
+ * pcap.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// A capturedPacket is a frame read from a capture file, along with the time
+// it was captured in microseconds and the type of its link layer.
+type capturedPacket struct {
+	ts       int64
+	linkType int
+	data     []byte
+}
+
+// A packetSource reads the packets of a capture file in turn, returning
+// os.EOF once there are no more.
+type packetSource interface {
+	next() (*capturedPacket, os.Error)
+}
+
+// The largest packet we are prepared to read, to guard against corrupt files
+const maxPacketSize = 1 << 18
+
+// openCapture starts reading a capture in either pcap or pcapng format,
+// telling them apart by their magic numbers.
+func openCapture(in io.Reader) (packetSource, os.Error) {
+	buffered := bufio.NewReader(in)
+	magic, err := buffered.Peek(4)
+	if err != nil {
+		return nil, os.NewError("not a pcap or pcapng capture")
+	}
+	switch binary.LittleEndian.Uint32(magic) {
+	case 0x0a0d0d0a:
+		return &pcapngReader{in: buffered}, nil
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return newPcapReader(buffered)
+	}
+	return nil, os.NewError("not a pcap or pcapng capture")
+}
+
+// readFull reads exactly len(buf) bytes. A capture which ends part way
+// through a packet is taken to have ended before it, as happens when a
+// capture is cut short.
+func readFull(in io.Reader, buf []byte) os.Error {
+	_, err := io.ReadFull(in, buf)
+	if err == io.ErrUnexpectedEOF {
+		debugMsg("The capture ends part way through a packet")
+		return os.EOF
+	}
+	return err
+}
+
+// A pcapReader reads the classic libpcap format, which has a single link
+// type for the whole file.
+type pcapReader struct {
+	in       io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType int
+}
+
+func newPcapReader(in io.Reader) (*pcapReader, os.Error) {
+	var hdr [24]byte
+	if err := readFull(in, hdr[0:]); err != nil {
+		return nil, os.NewError("truncated pcap header")
+	}
+	r := &pcapReader{in: in, order: binary.LittleEndian}
+	magic := binary.LittleEndian.Uint32(hdr[0:4])
+	if magic == 0xd4c3b2a1 || magic == 0x4d3cb2a1 {
+		r.order = binary.BigEndian
+	}
+	r.nano = magic == 0xa1b23c4d || magic == 0x4d3cb2a1
+	r.linkType = int(r.order.Uint32(hdr[20:24]) & 0xffff)
+	return r, nil
+}
+
+func (r *pcapReader) next() (*capturedPacket, os.Error) {
+	var hdr [16]byte
+	if err := readFull(r.in, hdr[0:]); err != nil {
+		return nil, err
+	}
+	caplen := r.order.Uint32(hdr[8:12])
+	if caplen > maxPacketSize {
+		return nil, os.NewError("corrupt pcap file")
+	}
+	p := &capturedPacket{linkType: r.linkType, data: make([]byte, caplen)}
+	if err := readFull(r.in, p.data); err != nil {
+		return nil, err
+	}
+	frac := int64(r.order.Uint32(hdr[4:8]))
+	if r.nano {
+		frac /= 1000
+	}
+	p.ts = int64(r.order.Uint32(hdr[0:4]))*1000000 + frac
+	return p, nil
+}
+
+// The pcapng blocks we read
+const (
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterfaceBlock = 0x00000001
+	pcapngPacket         = 0x00000002
+	pcapngEnhancedPacket = 0x00000006
+	pcapngOptionTsresol  = 9
+	pcapngByteOrderMagic = 0x1a2b3c4d
+)
+
+// A pcapngInterface describes an interface packets were captured on
+type pcapngInterface struct {
+	linkType int
+	// The number of timestamp units in a second
+	units float64
+}
+
+// A pcapngReader reads the pcapng format, in which each packet refers to the
+// interface it was captured on. Simple packet blocks carry no timestamp and
+// are skipped.
+type pcapngReader struct {
+	in     io.Reader
+	order  binary.ByteOrder
+	ifaces []pcapngInterface
+}
+
+// block reads the next block, returning its type and body
+func (r *pcapngReader) block() (uint32, []byte, os.Error) {
+	var head [8]byte
+	if err := readFull(r.in, head[0:]); err != nil {
+		return 0, nil, err
+	}
+	blockType := binary.LittleEndian.Uint32(head[0:4])
+	var body []byte
+	if blockType == pcapngSectionHeader {
+		// The byte order of the section is only known from the body
+		var magic [4]byte
+		if err := readFull(r.in, magic[0:]); err != nil {
+			return 0, nil, err
+		}
+		r.order = binary.LittleEndian
+		if binary.BigEndian.Uint32(magic[0:]) == pcapngByteOrderMagic {
+			r.order = binary.BigEndian
+		}
+		body = magic[0:]
+	} else if r.order == nil {
+		return 0, nil, os.NewError("pcapng file does not start with a section header")
+	} else {
+		blockType = r.order.Uint32(head[0:4])
+	}
+	length := r.order.Uint32(head[4:8])
+	if length < uint32(12+len(body)) || length%4 != 0 || length > maxPacketSize {
+		return 0, nil, os.NewError("corrupt pcapng file")
+	}
+	rest := make([]byte, int(length)-8-len(body))
+	if err := readFull(r.in, rest); err != nil {
+		return 0, nil, err
+	}
+	// Leave off the trailing copy of the block length
+	return blockType, append(body, rest[0:len(rest)-4]...), nil
+}
+
+func (r *pcapngReader) next() (*capturedPacket, os.Error) {
+	for {
+		blockType, body, err := r.block()
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case pcapngSectionHeader:
+			r.ifaces = nil
+		case pcapngInterfaceBlock:
+			if len(body) < 8 {
+				return nil, os.NewError("corrupt pcapng interface block")
+			}
+			r.ifaces = append(r.ifaces, pcapngInterface{
+				linkType: int(r.order.Uint16(body[0:2])),
+				units:    r.tsresol(body[8:]),
+			})
+		case pcapngEnhancedPacket, pcapngPacket:
+			if len(body) < 20 {
+				return nil, os.NewError("corrupt pcapng packet block")
+			}
+			var iface int
+			if blockType == pcapngEnhancedPacket {
+				iface = int(r.order.Uint32(body[0:4]))
+			} else {
+				iface = int(r.order.Uint16(body[0:2]))
+			}
+			if iface >= len(r.ifaces) {
+				return nil, os.NewError("pcapng packet from an undescribed interface")
+			}
+			caplen := int(r.order.Uint32(body[12:16]))
+			if caplen > len(body)-20 {
+				return nil, os.NewError("corrupt pcapng packet block")
+			}
+			ticks := uint64(r.order.Uint32(body[4:8]))<<32 |
+				uint64(r.order.Uint32(body[8:12]))
+			return &capturedPacket{
+				ts:       int64(float64(ticks) * 1e6 / r.ifaces[iface].units),
+				linkType: r.ifaces[iface].linkType,
+				data:     body[20 : 20+caplen],
+			}, nil
+		}
+	}
+	panic("unreachable")
+}
+
+// tsresol returns the number of timestamp units in a second, as given by
+// the options of an interface block. The default is microseconds.
+func (r *pcapngReader) tsresol(options []byte) float64 {
+	for len(options) >= 4 {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == pcapngOptionTsresol && length == 1 {
+			v := options[4]
+			units := 1.0
+			for i := byte(0); i < v&0x7f; i++ {
+				if v&0x80 != 0 {
+					units *= 2
+				} else {
+					units *= 10
+				}
+			}
+			return units
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return 1e6
+}
+
+// Link layer types
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSll      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSll2     = 276
+)
+
+// A packet is the part of a TCP or UDP packet which flow statistics are
+// computed from.
+type packet struct {
+	ts        int64
+	src, dst  []byte
+	sport     int
+	dport     int
+	proto     int
+	length    int // The length of the IP packet
+	headerLen int // The length of the IP and transport headers
+	flags     byte
+	dscp      int
+}
+
+// TCP flags counted in flow statistics
+const (
+	tcpPsh = 0x08
+	tcpUrg = 0x20
+)
+
+// Transport protocols flows are built from
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// decodePacket decodes the captured frame into p, reporting whether it held
+// a TCP or UDP packet over IPv4 or IPv6. Fragments other than the first are
+// left out, since they carry no ports.
+func decodePacket(c *capturedPacket, p *packet) bool {
+	b := c.data
+	version := 0
+	switch c.linkType {
+	case linkEthernet:
+		if len(b) < 14 {
+			return false
+		}
+		etherType, off := binary.BigEndian.Uint16(b[12:14]), 14
+		// Skip any VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(b) >= off+4 {
+			etherType = binary.BigEndian.Uint16(b[off+2 : off+4])
+			off += 4
+		}
+		b, version = b[off:], etherVersion(etherType)
+	case linkSll:
+		if len(b) < 16 {
+			return false
+		}
+		b, version = b[16:], etherVersion(binary.BigEndian.Uint16(b[14:16]))
+	case linkSll2:
+		if len(b) < 20 {
+			return false
+		}
+		b, version = b[20:], etherVersion(binary.BigEndian.Uint16(b[0:2]))
+	case linkNull, linkLoop:
+		if len(b) < 4 {
+			return false
+		}
+		family := binary.LittleEndian.Uint32(b[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(b[0:4])
+		}
+		switch family {
+		case 2:
+			version = 4
+		case 10, 24, 28, 30:
+			version = 6
+		}
+		b = b[4:]
+	case linkRaw, 12, 14:
+		if len(b) > 0 {
+			version = int(b[0] >> 4)
+		}
+	case linkIPv4:
+		version = 4
+	case linkIPv6:
+		version = 6
+	}
+	p.ts = c.ts
+	var off int
+	switch version {
+	case 4:
+		if len(b) < 20 {
+			return false
+		}
+		off = int(b[0]&0x0f) * 4
+		if off < 20 || binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+			return false
+		}
+		p.length = int(binary.BigEndian.Uint16(b[2:4]))
+		p.proto = int(b[9])
+		p.dscp = int(b[1] >> 2)
+		p.src, p.dst = b[12:16], b[16:20]
+	case 6:
+		if len(b) < 40 {
+			return false
+		}
+		p.length = 40 + int(binary.BigEndian.Uint16(b[4:6]))
+		p.dscp = int(binary.BigEndian.Uint16(b[0:2])>>4&0xff) >> 2
+		p.src, p.dst = b[8:24], b[24:40]
+		next := b[6]
+		off = 40
+		// Skip any extension headers
+		for next == 0 || next == 43 || next == 44 || next == 60 {
+			if len(b) < off+8 {
+				return false
+			}
+			if next == 44 {
+				if binary.BigEndian.Uint16(b[off+2:off+4])&0xfff8 != 0 {
+					return false
+				}
+				next, off = b[off], off+8
+			} else {
+				next, off = b[off], off+(int(b[off+1])+1)*8
+			}
+		}
+		p.proto = int(next)
+	default:
+		return false
+	}
+	switch p.proto {
+	case protoTCP:
+		if len(b) < off+14 {
+			return false
+		}
+		p.headerLen = off + int(b[off+12]>>4)*4
+		p.flags = b[off+13]
+	case protoUDP:
+		if len(b) < off+4 {
+			return false
+		}
+		p.headerLen = off + 8
+		p.flags = 0
+	default:
+		return false
+	}
+	p.sport = int(binary.BigEndian.Uint16(b[off : off+2]))
+	p.dport = int(binary.BigEndian.Uint16(b[off+2 : off+4]))
+	return true
+}
+
+// etherVersion returns the IP version carried by frames of the given
+// EtherType, or 0 if they do not carry IP.
+func etherVersion(etherType uint16) int {
+	switch etherType {
+	case 0x0800:
+		return 4
+	case 0x86dd:
+		return 6
+	}
+	return 0
+}
@@ -0,0 +1,453 @@
+/* 
+ * pcap_test.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// put32 and put16 append v to buf in the given byte order
+func put32(buf *bytes.Buffer, order binary.ByteOrder, v uint32) {
+	var b [4]byte
+	order.PutUint32(b[0:], v)
+	buf.Write(b[0:])
+}
+
+func put16(buf *bytes.Buffer, order binary.ByteOrder, v uint16) {
+	var b [2]byte
+	order.PutUint16(b[0:], v)
+	buf.Write(b[0:])
+}
+
+// pcapHeader returns the header of a pcap file with the given magic number
+// and link type
+func pcapHeader(order binary.ByteOrder, magic uint32, linkType uint32) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	put32(buf, order, magic)
+	put16(buf, order, 2)
+	put16(buf, order, 4)
+	put32(buf, order, 0)
+	put32(buf, order, 0)
+	put32(buf, order, 65535)
+	put32(buf, order, linkType)
+	return buf
+}
+
+// pcapRecord appends a packet captured at the given time to a pcap file
+func pcapRecord(buf *bytes.Buffer, order binary.ByteOrder, sec, frac uint32,
+	data []byte) {
+	put32(buf, order, sec)
+	put32(buf, order, frac)
+	put32(buf, order, uint32(len(data)))
+	put32(buf, order, uint32(len(data)))
+	buf.Write(data)
+}
+
+// pcapngBlock appends a block with the given type and body to a pcapng
+// file, padding the body to a multiple of four bytes
+func pcapngBlock(buf *bytes.Buffer, order binary.ByteOrder, blockType uint32,
+	body []byte) {
+	pad := (4 - len(body)%4) % 4
+	put32(buf, order, blockType)
+	put32(buf, order, uint32(12+len(body)+pad))
+	buf.Write(body)
+	buf.Write(make([]byte, pad))
+	put32(buf, order, uint32(12+len(body)+pad))
+}
+
+// pcapngStart returns the section header and a description of a single
+// interface with the given link type and tsresol option, or no options if
+// tsresol is zero
+func pcapngStart(order binary.ByteOrder, linkType uint16, tsresol byte) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	body := new(bytes.Buffer)
+	put32(body, order, pcapngByteOrderMagic)
+	put16(body, order, 1)
+	put16(body, order, 0)
+	put32(body, order, 0xffffffff)
+	put32(body, order, 0xffffffff)
+	pcapngBlock(buf, order, pcapngSectionHeader, body.Bytes())
+	body.Reset()
+	put16(body, order, linkType)
+	put16(body, order, 0)
+	put32(body, order, 65535)
+	if tsresol != 0 {
+		put16(body, order, pcapngOptionTsresol)
+		put16(body, order, 1)
+		body.Write([]byte{tsresol, 0, 0, 0})
+		put32(body, order, 0)
+	}
+	pcapngBlock(buf, order, pcapngInterfaceBlock, body.Bytes())
+	return buf
+}
+
+// pcapngEnhanced appends an enhanced packet block captured on interface 0
+func pcapngEnhanced(buf *bytes.Buffer, order binary.ByteOrder, ticks uint64,
+	data []byte) {
+	body := new(bytes.Buffer)
+	put32(body, order, 0)
+	put32(body, order, uint32(ticks>>32))
+	put32(body, order, uint32(ticks))
+	put32(body, order, uint32(len(data)))
+	put32(body, order, uint32(len(data)))
+	body.Write(data)
+	pcapngBlock(buf, order, pcapngEnhancedPacket, body.Bytes())
+}
+
+var pcapTests = []struct {
+	name  string
+	order binary.ByteOrder
+	magic uint32
+	frac  uint32
+}{
+	{"little endian", binary.LittleEndian, 0xa1b2c3d4, 500},
+	{"big endian", binary.BigEndian, 0xa1b2c3d4, 500},
+	{"nanoseconds", binary.LittleEndian, 0xa1b23c4d, 500000},
+	{"big endian nanoseconds", binary.BigEndian, 0xa1b23c4d, 500000},
+}
+
+func TestPcapReader(t *testing.T) {
+	for _, tt := range pcapTests {
+		buf := pcapHeader(tt.order, tt.magic, linkRaw)
+		pcapRecord(buf, tt.order, 1, tt.frac, []byte("abc"))
+		pcapRecord(buf, tt.order, 2, 0, []byte("defg"))
+		// A packet cut short by the end of the capture is left out
+		put32(buf, tt.order, 3)
+		put32(buf, tt.order, 0)
+		put32(buf, tt.order, 8)
+		put32(buf, tt.order, 8)
+		buf.WriteString("hi")
+		source, err := openCapture(buf)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		for i, want := range []struct {
+			ts   int64
+			data string
+		}{{1000500, "abc"}, {2000000, "defg"}} {
+			p, err := source.next()
+			if err != nil {
+				t.Errorf("%s: packet %d: %s", tt.name, i, err)
+				break
+			}
+			if p.ts != want.ts || p.linkType != linkRaw || string(p.data) != want.data {
+				t.Errorf("%s: packet %d = %d %d %q, want %d %d %q", tt.name, i,
+					p.ts, p.linkType, p.data, want.ts, linkRaw, want.data)
+			}
+		}
+		if _, err = source.next(); err != os.EOF {
+			t.Errorf("%s: read %v after the last packet, want EOF", tt.name, err)
+		}
+	}
+}
+
+var pcapngTests = []struct {
+	name    string
+	order   binary.ByteOrder
+	tsresol byte
+	ticks   uint64
+	ts      int64
+}{
+	{"microseconds", binary.LittleEndian, 0, 1000500, 1000500},
+	{"big endian", binary.BigEndian, 0, 1000500, 1000500},
+	{"nanoseconds", binary.LittleEndian, 9, 1000500000, 1000500},
+	{"powers of two", binary.BigEndian, 0x80 | 10, 3 << 10, 3000000},
+	{"high word", binary.LittleEndian, 9, 5000000000000, 5000000000},
+}
+
+func TestPcapngReader(t *testing.T) {
+	for _, tt := range pcapngTests {
+		buf := pcapngStart(tt.order, linkEthernet, tt.tsresol)
+		// Simple packet blocks have no timestamp and are skipped
+		pcapngBlock(buf, tt.order, 3, []byte{3, 0, 0, 0, 'x', 'y', 'z', 0})
+		pcapngEnhanced(buf, tt.order, tt.ticks, []byte("abcde"))
+		source, err := openCapture(buf)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		p, err := source.next()
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if p.ts != tt.ts || p.linkType != linkEthernet || string(p.data) != "abcde" {
+			t.Errorf("%s: packet = %d %d %q, want %d %d %q", tt.name,
+				p.ts, p.linkType, p.data, tt.ts, linkEthernet, "abcde")
+		}
+		if _, err = source.next(); err != os.EOF {
+			t.Errorf("%s: read %v after the last packet, want EOF", tt.name, err)
+		}
+	}
+}
+
+// captureErrorTests are captures which cannot be read, with the error each
+// gives
+var captureErrorTests = []struct {
+	name    string
+	capture func() []byte
+	err     string
+}{
+	{"not a capture", func() []byte {
+		return []byte("srcip,srcport\n")
+	}, "not a pcap or pcapng capture"},
+	{"oversized pcap packet", func() []byte {
+		buf := pcapHeader(binary.LittleEndian, 0xa1b2c3d4, linkRaw)
+		pcapRecord(buf, binary.LittleEndian, 1, 0, nil)
+		binary.LittleEndian.PutUint32(buf.Bytes()[24+8:], maxPacketSize+1)
+		return buf.Bytes()
+	}, "corrupt pcap file"},
+	{"block length", func() []byte {
+		buf := pcapngStart(binary.LittleEndian, linkEthernet, 0)
+		put32(buf, binary.LittleEndian, pcapngEnhancedPacket)
+		put32(buf, binary.LittleEndian, 10)
+		return buf.Bytes()
+	}, "corrupt pcapng file"},
+	{"undescribed interface", func() []byte {
+		buf := new(bytes.Buffer)
+		body := new(bytes.Buffer)
+		put32(body, binary.LittleEndian, pcapngByteOrderMagic)
+		put32(body, binary.LittleEndian, 1)
+		put32(body, binary.LittleEndian, 0xffffffff)
+		put32(body, binary.LittleEndian, 0xffffffff)
+		pcapngBlock(buf, binary.LittleEndian, pcapngSectionHeader, body.Bytes())
+		pcapngEnhanced(buf, binary.LittleEndian, 0, []byte("a"))
+		return buf.Bytes()
+	}, "pcapng packet from an undescribed interface"},
+}
+
+func TestCaptureErrors(t *testing.T) {
+	for _, tt := range captureErrorTests {
+		source, err := openCapture(bytes.NewBuffer(tt.capture()))
+		if err == nil {
+			_, err = source.next()
+		}
+		if err == nil || err.String() != tt.err {
+			t.Errorf("%s: error = %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+// ipv4 returns an IPv4 packet from 10.0.0.1 to 10.0.0.2 holding the payload
+func ipv4(proto byte, dscp byte, fragment uint16, payload []byte) []byte {
+	b := make([]byte, 20, 20+len(payload))
+	b[0], b[1], b[8], b[9] = 0x45, dscp<<2, 64, proto
+	binary.BigEndian.PutUint16(b[2:4], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(b[6:8], fragment)
+	copy(b[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	return append(b, payload...)
+}
+
+// reply returns the IPv4 packet b as sent back from 10.0.0.2 to 10.0.0.1
+func reply(b []byte) []byte {
+	copy(b[12:], []byte{10, 0, 0, 2, 10, 0, 0, 1})
+	return b
+}
+
+// ipv6 returns an IPv6 packet from 2001:db8::1 to 2001:db8::2 holding the
+// payload, which starts with a header of the given type
+func ipv6(next byte, payload []byte) []byte {
+	b := make([]byte, 40, 40+len(payload))
+	b[0], b[6], b[7] = 0x60, next, 64
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(payload)))
+	copy(b[8:], net.ParseIP("2001:db8::1"))
+	copy(b[24:], net.ParseIP("2001:db8::2"))
+	return append(b, payload...)
+}
+
+// tcp returns a TCP header with no options
+func tcp(sport, dport uint16, flags byte) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:2], sport)
+	binary.BigEndian.PutUint16(b[2:4], dport)
+	b[12], b[13] = 5<<4, flags
+	return b
+}
+
+// udp returns a UDP header
+func udp(sport, dport uint16) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b[0:2], sport)
+	binary.BigEndian.PutUint16(b[2:4], dport)
+	return b
+}
+
+// ether returns an Ethernet frame of the given type, with any VLAN tags
+// given before it
+func ether(payload []byte, etherTypes ...uint16) []byte {
+	b := make([]byte, 12)
+	for i, t := range etherTypes {
+		if i > 0 {
+			b = append(b, 0, 1)
+		}
+		b = append(b, byte(t>>8), byte(t))
+	}
+	return append(b, payload...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+var decodeTests = []struct {
+	name     string
+	linkType int
+	data     []byte
+	ok       bool
+	// The fields decoded from the packet
+	src                 string
+	sport, dport, proto int
+	length, headerLen   int
+	flags               byte
+	dscp                int
+}{
+	{"ethernet tcp", linkEthernet,
+		ether(ipv4(protoTCP, 10, 0, concat(tcp(1234, 80, tcpPsh), []byte("data"))), 0x0800),
+		true, "10.0.0.1", 1234, 80, protoTCP, 44, 40, tcpPsh, 10},
+	{"vlan udp", linkEthernet,
+		ether(ipv4(protoUDP, 0, 0, udp(53, 5353)), 0x8100, 0x0800),
+		true, "10.0.0.1", 53, 5353, protoUDP, 28, 28, 0, 0},
+	{"ipv6 extension header", linkEthernet,
+		ether(ipv6(0, concat([]byte{protoTCP, 0, 0, 0, 0, 0, 0, 0},
+			tcp(22, 4000, tcpUrg))), 0x86dd),
+		true, "2001:db8::1", 22, 4000, protoTCP, 68, 68, tcpUrg, 0},
+	{"linux cooked", linkSll,
+		concat(make([]byte, 14), []byte{0x08, 0x00}, ipv4(protoUDP, 0, 0, udp(1, 2))),
+		true, "10.0.0.1", 1, 2, protoUDP, 28, 28, 0, 0},
+	{"loopback", linkNull,
+		concat([]byte{2, 0, 0, 0}, ipv4(protoTCP, 0, 0, tcp(5, 6, 0))),
+		true, "10.0.0.1", 5, 6, protoTCP, 40, 40, 0, 0},
+	{"raw ipv6", linkRaw, ipv6(protoUDP, udp(7, 8)),
+		true, "2001:db8::1", 7, 8, protoUDP, 48, 48, 0, 0},
+	{"first fragment", linkIPv4, ipv4(protoUDP, 0, 0x2000, udp(9, 10)),
+		true, "10.0.0.1", 9, 10, protoUDP, 28, 28, 0, 0},
+	{"later fragment", linkIPv4, ipv4(protoUDP, 0, 0x0010, udp(9, 10)), false,
+		"", 0, 0, 0, 0, 0, 0, 0},
+	{"icmp", linkRaw, ipv4(1, 0, 0, make([]byte, 8)), false,
+		"", 0, 0, 0, 0, 0, 0, 0},
+	{"arp", linkEthernet, ether(make([]byte, 28), 0x0806), false,
+		"", 0, 0, 0, 0, 0, 0, 0},
+	{"truncated tcp", linkRaw, ipv4(protoTCP, 0, 0, make([]byte, 10)), false,
+		"", 0, 0, 0, 0, 0, 0, 0},
+}
+
+func TestDecodePacket(t *testing.T) {
+	for _, tt := range decodeTests {
+		var p packet
+		c := &capturedPacket{ts: 42, linkType: tt.linkType, data: tt.data}
+		if ok := decodePacket(c, &p); ok != tt.ok {
+			t.Errorf("%s: decodePacket = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if src := net.IP(p.src).String(); src != tt.src || p.ts != 42 ||
+			p.sport != tt.sport || p.dport != tt.dport || p.proto != tt.proto {
+			t.Errorf("%s: decoded %s:%d > :%d proto %d, want %s:%d > :%d proto %d",
+				tt.name, src, p.sport, p.dport, p.proto,
+				tt.src, tt.sport, tt.dport, tt.proto)
+		}
+		if p.length != tt.length || p.headerLen != tt.headerLen ||
+			p.flags != tt.flags || p.dscp != tt.dscp {
+			t.Errorf("%s: length %d, header %d, flags %#x, dscp %d, "+
+				"want %d, %d, %#x, %d", tt.name, p.length, p.headerLen,
+				p.flags, p.dscp, tt.length, tt.headerLen, tt.flags, tt.dscp)
+		}
+	}
+}
+
+func TestCaptureFlows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adp")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	defer os.RemoveAll(dir)
+	order := binary.LittleEndian
+	buf := pcapHeader(order, 0xa1b2c3d4, linkRaw)
+	pcapRecord(buf, order, 1, 0, ipv4(protoTCP, 0, 0, tcp(1234, 80, 0)))
+	pcapRecord(buf, order, 1, 250,
+		reply(ipv4(protoTCP, 0, 0, concat(tcp(80, 1234, tcpPsh), []byte("ok")))))
+	fileName := filepath.Join(dir, "c.pcap")
+	if err = ioutil.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.String())
+	}
+	csvName, err := newOutputNames(fileName, ".csv").name(".csv")
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	if err = captureFlows(fileName); err != nil {
+		t.Fatal(err.String())
+	}
+	text, err := ioutil.ReadFile(csvName)
+	if err != nil {
+		t.Fatal(err.String())
+	}
+	lines := strings.Split(strings.TrimRight(string(text), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("wrote %d flows, want 1:\n%s", len(lines), text)
+	}
+	// The flow has one packet each way, and fits the flow schema but for
+	// its label
+	fields := strings.Split(lines[0], ",")
+	if err = builtinSchemas["flow"].checkRow(fields, false); err != nil {
+		t.Errorf("flow does not fit the flow schema: %s", err)
+	}
+	want := "10.0.0.1,1234,10.0.0.2,80,6,1,40,1,42,"
+	if !strings.HasPrefix(lines[0], want) {
+		t.Errorf("flow = %s, want it to start %s", lines[0], want)
+	}
+
+	// A capture which cannot be read to the end leaves no flows behind
+	binary.LittleEndian.PutUint32(buf.Bytes()[24+8:], maxPacketSize+1)
+	if err = ioutil.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.String())
+	}
+	if err = os.Remove(csvName); err != nil {
+		t.Fatal(err.String())
+	}
+	if err = captureFlows(fileName); err == nil {
+		t.Errorf("captureFlows read a corrupt capture")
+	}
+	if _, err = os.Stat(csvName); err == nil {
+		t.Errorf("captureFlows left %s behind", csvName)
+	}
+}
@@ -0,0 +1,517 @@
+/* 
+ * report.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"rand"
+	"sort"
+	"strings"
+	"tabwriter"
+)
+
+var (
+	reportBinsFlag = flag.Int("report-bins", 10,
+		"most histogram bins numeric columns are split into by report, at "+
+			"quantiles of their values")
+	reportSortFlag = flag.String("report-sort", "ratio",
+		"what report ranks columns by: gain (information gain), ratio "+
+			"(gain ratio) or chi2 (chi-squared, for nominal columns)")
+)
+
+// entropy returns the entropy, in bits, of the distribution given by counts
+func entropy(counts []float64) float64 {
+	total := 0.0
+	for _, n := range counts {
+		total += n
+	}
+	h := 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := n / total
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// A contingency table counts the instances of each class holding each value,
+// or falling in each bin, of a column.
+type contingency [][]float64
+
+// classTotals returns the number of instances of each class in the table
+func (t contingency) classTotals(classes int) []float64 {
+	totals := make([]float64, classes)
+	for _, row := range t {
+		for c, n := range row {
+			totals[c] += n
+		}
+	}
+	return totals
+}
+
+// gain returns the information gain about the class from knowing the row,
+// and the gain ratio, which is the gain divided by the entropy of the rows
+func (t contingency) gain(classes int) (gain, ratio float64) {
+	total := 0.0
+	rows := make([]float64, len(t))
+	for i, row := range t {
+		for _, n := range row {
+			rows[i] += n
+		}
+		total += rows[i]
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	gain = entropy(t.classTotals(classes))
+	for i, row := range t {
+		gain -= rows[i] / total * entropy(row)
+	}
+	if split := entropy(rows); split > 0 {
+		ratio = gain / split
+	}
+	return gain, ratio
+}
+
+// chiSquared returns Pearson's chi-squared statistic for the independence of
+// the rows and classes of the table, and its degrees of freedom
+func (t contingency) chiSquared(classes int) (float64, int) {
+	totals := t.classTotals(classes)
+	total, usedClasses := 0.0, 0
+	for _, n := range totals {
+		total += n
+		if n > 0 {
+			usedClasses++
+		}
+	}
+	chi2, usedRows := 0.0, 0
+	for _, row := range t {
+		rowTotal := 0.0
+		for _, n := range row {
+			rowTotal += n
+		}
+		if rowTotal == 0 {
+			continue
+		}
+		usedRows++
+		for c, n := range row {
+			if totals[c] > 0 {
+				expected := rowTotal * totals[c] / total
+				chi2 += (n - expected) * (n - expected) / expected
+			}
+		}
+	}
+	if usedRows < 2 || usedClasses < 2 {
+		return 0, 0
+	}
+	return chi2, (usedRows - 1) * (usedClasses - 1)
+}
+
+// A classProfile gathers the values of a numeric column for one class
+type classProfile struct {
+	count   int
+	missing int
+	stats   runningStats
+	sample  reservoir
+}
+
+// A columnBreakdown gathers the values of a column, bucketed by label as the
+// split does
+type columnBreakdown struct {
+	f   *feature
+	rng *rand.Rand
+	// For numeric columns, the values of each class and a sample of them
+	// all, from which the bins are made
+	classes []*classProfile
+	all     runningStats
+	sample  reservoir
+	// For other columns, the number of each value found in each class.
+	// Once there are -stats-distinct values, any others are counted as one.
+	values *codeMap
+	counts contingency
+	capped bool
+}
+
+// The value other values are counted as, once there are too many to count
+const otherValues = "(other)"
+
+func (b *columnBreakdown) class(c int) *classProfile {
+	for len(b.classes) <= c {
+		b.classes = append(b.classes,
+			&classProfile{sample: reservoir{size: *statsSampleFlag, rng: b.rng}})
+	}
+	return b.classes[c]
+}
+
+// add counts a value of the column found in an instance of class c. Missing
+// values of nominal columns are counted as a value of their own.
+func (b *columnBreakdown) add(v value, c int) {
+	if b.f.isNumeric() {
+		p := b.class(c)
+		if v.missing {
+			p.missing++
+			return
+		}
+		p.count++
+		p.stats.add(v.num)
+		p.sample.add(v.num)
+		b.all.add(v.num)
+		b.sample.add(v.num)
+		return
+	}
+	s := v.String()
+	if _, exists := b.values.codes[s]; !exists && len(b.values.values) >= *statsDistinctFlag {
+		s, b.capped = otherValues, true
+	}
+	row := b.values.code(s)
+	for len(b.counts) <= row {
+		b.counts = append(b.counts, nil)
+	}
+	for len(b.counts[row]) <= c {
+		b.counts[row] = append(b.counts[row], 0)
+	}
+	b.counts[row][c]++
+}
+
+// edges returns the edges of at most n bins holding about as many values of
+// the column each, found at the quantiles of the sample of its values. Where
+// many values are the same, as with the counters of flows, the bins which
+// would be empty are left out, so there may be fewer than n.
+func (b *columnBreakdown) edges(n int) []float64 {
+	qs := make([]float64, n+1)
+	for i := range qs {
+		qs[i] = float64(i) / float64(n)
+	}
+	var edges []float64
+	for _, e := range b.sample.quantiles(qs) {
+		if len(edges) == 0 || e > edges[len(edges)-1] {
+			edges = append(edges, e)
+		}
+	}
+	if len(edges) == 1 {
+		edges = append(edges, edges[0])
+	}
+	return edges
+}
+
+// histogram returns the number of values of each class falling in each of
+// the bins between edges, with missing values counted in a further bin. The
+// last bin takes in its upper edge. Where a class has more values than were
+// sampled, the counts of its sample are scaled up to its size.
+func (b *columnBreakdown) histogram(edges []float64, classes int) contingency {
+	n := len(edges) - 1
+	t := make(contingency, n+1)
+	for i := range t {
+		t[i] = make([]float64, classes)
+	}
+	for c, p := range b.classes {
+		t[n][c] = float64(p.missing)
+		if len(p.sample.sample) == 0 {
+			continue
+		}
+		scale := float64(p.count) / float64(len(p.sample.sample))
+		for _, x := range p.sample.sample {
+			bin := 0
+			for bin < n-1 && x >= edges[bin+1] {
+				bin++
+			}
+			t[bin][c] += scale
+		}
+	}
+	return t
+}
+
+// classSummary describes the values of a column in one class
+type classSummary struct {
+	Label   string    `json:"label"`
+	Count   int       `json:"count"`
+	Missing int       `json:"missing"`
+	Mean    float64   `json:"mean"`
+	Median  float64   `json:"median"`
+	Bins    []float64 `json:"histogram,omitempty"`
+	// The most common values of a nominal column
+	Top []valueCount `json:"top,omitempty"`
+}
+
+// columnSeparability describes how well a column separates the classes
+type columnSeparability struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Gain    float64 `json:"gain"`
+	Ratio   float64 `json:"gain_ratio"`
+	Chi2    float64 `json:"chi2,omitempty"`
+	Freedom int     `json:"chi2_df,omitempty"`
+	Capped  bool    `json:"distinct_capped,omitempty"`
+	// The edges of the histogram bins of a numeric column
+	Edges   []float64      `json:"bin_edges,omitempty"`
+	Classes []classSummary `json:"classes"`
+}
+
+// summary describes the column, given the labels of the classes
+func (b *columnBreakdown) summary(labels []string) columnSeparability {
+	s := columnSeparability{Name: b.f.name, Type: b.f.datatype, Capped: b.capped}
+	if !b.f.isNumeric() {
+		for i := range b.counts {
+			for len(b.counts[i]) < len(labels) {
+				b.counts[i] = append(b.counts[i], 0)
+			}
+		}
+		s.Gain, s.Ratio = b.counts.gain(len(labels))
+		s.Chi2, s.Freedom = b.counts.chiSquared(len(labels))
+		for c, label := range labels {
+			counts := map[string]int{}
+			summary := classSummary{Label: label}
+			for i, row := range b.counts {
+				if row[c] > 0 {
+					counts[b.values.values[i]] = int(row[c])
+					summary.Count += int(row[c])
+				}
+			}
+			summary.Missing = counts["?"]
+			summary.Count -= summary.Missing
+			summary.Top = sortedCounts(counts)
+			if len(summary.Top) > statsTopValues {
+				summary.Top = summary.Top[0:statsTopValues]
+			}
+			s.Classes = append(s.Classes, summary)
+		}
+		return s
+	}
+	s.Edges = b.edges(*reportBinsFlag)
+	n := len(s.Edges) - 1
+	hist := b.histogram(s.Edges, len(labels))
+	s.Gain, s.Ratio = hist.gain(len(labels))
+	for c, label := range labels {
+		p := b.class(c)
+		summary := classSummary{Label: label, Count: p.count, Missing: p.missing,
+			Mean: p.stats.mean, Median: p.sample.quantiles([]float64{0.5})[0]}
+		for i := 0; i < n; i++ {
+			summary.Bins = append(summary.Bins, math.Floor(hist[i][c]+0.5))
+		}
+		s.Classes = append(s.Classes, summary)
+	}
+	return s
+}
+
+// separabilityReport describes how well each column of a data set separates
+// its classes, from the best to the worst
+type separabilityReport struct {
+	File    string               `json:"file"`
+	Class   string               `json:"class"`
+	Rows    int                  `json:"rows"`
+	Labels  []valueCount         `json:"labels"`
+	SortBy  string               `json:"sort_by"`
+	Columns []columnSeparability `json:"columns"`
+}
+
+// bySeparation sorts columns from the best to the worst separating
+type bySeparation struct {
+	columns []columnSeparability
+	key     func(c *columnSeparability) float64
+}
+
+func (s bySeparation) Len() int { return len(s.columns) }
+func (s bySeparation) Swap(i, j int) {
+	s.columns[i], s.columns[j] = s.columns[j], s.columns[i]
+}
+func (s bySeparation) Less(i, j int) bool {
+	return s.key(&s.columns[i]) > s.key(&s.columns[j])
+}
+
+// separation returns the measure columns are ranked by
+func separation(sortBy string) (func(c *columnSeparability) float64, os.Error) {
+	switch sortBy {
+	case "gain":
+		return func(c *columnSeparability) float64 { return c.Gain }, nil
+	case "ratio":
+		return func(c *columnSeparability) float64 { return c.Ratio }, nil
+	case "chi2":
+		return func(c *columnSeparability) float64 { return c.Chi2 }, nil
+	}
+	return nil, os.NewError("unknown report ranking " + sortBy)
+}
+
+// A labelBreakdown breaks each column of a data set down by the label of
+// each instance
+type labelBreakdown struct {
+	class   int
+	labels  *codeMap
+	counts  map[string]int
+	rows    int
+	columns []*columnBreakdown
+}
+
+func newLabelBreakdown(hdr *header, class int) *labelBreakdown {
+	b := &labelBreakdown{class: class, labels: newCodeMap(hdr.attr(class).nominal),
+		counts: map[string]int{}}
+	rng := newSampleRand()
+	for i := 0; i < hdr.features.Len(); i++ {
+		if i != class {
+			b.columns = append(b.columns, &columnBreakdown{f: hdr.attr(i),
+				rng: rng, values: newCodeMap(nil),
+				sample: reservoir{size: *statsSampleFlag, rng: rng}})
+		}
+	}
+	return b
+}
+
+func (b *labelBreakdown) add(inst *instance) {
+	b.rows++
+	label := inst.values[b.class].String()
+	b.counts[label]++
+	c := b.labels.code(label)
+	j := 0
+	for i, v := range inst.values {
+		if i != b.class {
+			b.columns[j].add(v, c)
+			j++
+		}
+	}
+}
+
+// summaries describes how well each column separates the labels, in the
+// order of the columns
+func (b *labelBreakdown) summaries() []columnSeparability {
+	var summaries []columnSeparability
+	for _, c := range b.columns {
+		summaries = append(summaries, c.summary(b.labels.values))
+	}
+	return summaries
+}
+
+// breakdown reads the named data file once, breaking each of its columns
+// down by the label of each instance
+func breakdown(fileName string) (*separabilityReport, os.Error) {
+	key, err := separation(*reportSortFlag)
+	if err != nil {
+		return nil, err
+	}
+	reader, dataFile, err := openDataReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+	hdr := reader.header()
+	class, err := classIndex(hdr)
+	if err != nil {
+		return nil, err
+	}
+	b := newLabelBreakdown(hdr, class)
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.add(inst)
+	}
+	report := &separabilityReport{File: fileName, Class: hdr.attr(class).name,
+		Rows: b.rows, SortBy: *reportSortFlag, Columns: b.summaries()}
+	for _, label := range b.labels.values {
+		report.Labels = append(report.Labels, valueCount{label, b.counts[label]})
+	}
+	sort.Sort(bySeparation{report.Columns, key})
+	return report, nil
+}
+
+// writeText writes the report as tables: a ranking of the columns, followed
+// by the breakdown of each column by class
+func (r *separabilityReport) writeText(out *bufio.Writer) os.Error {
+	fmt.Fprintf(out, "%s: %d rows, class %s, ranked by %s\n\n", r.File, r.Rows,
+		r.Class, r.SortBy)
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "rank\tcolumn\ttype\tgain\tgain ratio\tchi2\tdf")
+	for i, c := range r.Columns {
+		chi2, df := "", ""
+		if c.Freedom > 0 {
+			chi2, df = formatNumber(c.Chi2), fmt.Sprint(c.Freedom)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.4f\t%.4f\t%s\t%s\n", i+1, c.Name, c.Type,
+			c.Gain, c.Ratio, chi2, df)
+	}
+	for _, c := range r.Columns {
+		fmt.Fprintf(w, "\n%s (%s)\n", c.Name, c.Type)
+		if c.Edges != nil {
+			var edges []string
+			for _, e := range c.Edges {
+				edges = append(edges, formatNumber(e))
+			}
+			fmt.Fprintf(w, "bins: %s\n", strings.Join(edges, " "))
+			fmt.Fprintln(w, "label\tcount\tmissing\tmean\tmedian\thistogram")
+		} else {
+			fmt.Fprintln(w, "label\tcount\tmissing\ttop values")
+		}
+		for _, s := range c.Classes {
+			if c.Edges != nil {
+				var bins []string
+				for _, n := range s.Bins {
+					bins = append(bins, formatNumber(n))
+				}
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", s.Label, s.Count,
+					s.Missing, formatNumber(s.Mean), formatNumber(s.Median),
+					strings.Join(bins, " "))
+				continue
+			}
+			var top []string
+			for _, v := range s.Top {
+				top = append(top, fmt.Sprintf("%s (%d)", v.Value, v.Count))
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", s.Label, s.Count, s.Missing,
+				strings.Join(top, ", "))
+		}
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// writeReports writes the report of each of the files to standard output, in
+// the format given by -stats-format
+func writeReports(files []string) os.Error {
+	return writeSummaries(files, func(fileName string) (textWriter, os.Error) {
+		return breakdown(fileName)
+	})
+}
+
+// commandReport shows how well each column of each of the files separates
+// its classes
+func commandReport(files []string) {
+	errCheck(writeReports(files))
+}
+
+func init() {
+	commands["report"] = command{
+		"rank the columns of labeled data by how well they separate the labels",
+		commandReport}
+}
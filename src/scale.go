@@ -0,0 +1,303 @@
+/* 
+ * scale.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"json"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	scaleFlag = flag.String("scale", "zscore",
+		"how fit scales numeric columns: minmax, zscore, robust (median and "+
+			"interquartile range) or log1p")
+	scalingFlag = flag.String("scaling", "",
+		"file of scaling parameters, as written by fit, to scale numeric "+
+			"columns by as they are converted")
+)
+
+// columnScaling scales a column as y = (f(x) - Offset) / Scale, where f is
+// log1p for the log1p method, and nothing otherwise
+type columnScaling struct {
+	Name   string  `json:"name"`
+	Offset float64 `json:"offset"`
+	Scale  float64 `json:"scale"`
+}
+
+// scaling holds the parameters fitted to the columns of a training set
+type scaling struct {
+	Method  string          `json:"method"`
+	Fitted  string          `json:"fitted_on"`
+	Columns []columnScaling `json:"columns"`
+}
+
+// log1p returns log(1+x), keeping the sign of negative values
+func log1p(x float64) float64 {
+	if x < 0 {
+		return -math.Log1p(-x)
+	}
+	return math.Log1p(x)
+}
+
+// apply returns x scaled
+func (s *scaling) apply(c *columnScaling, x float64) float64 {
+	if s.Method == "log1p" {
+		x = log1p(x)
+	}
+	return (x - c.Offset) / c.Scale
+}
+
+// loadScaling reads the named file of scaling parameters
+func loadScaling(fileName string) (*scaling, os.Error) {
+	text, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	s := new(scaling)
+	if err = json.Unmarshal(text, s); err != nil {
+		return nil, os.NewError(fileName + ": " + err.String())
+	}
+	switch s.Method {
+	case "minmax", "zscore", "robust", "log1p":
+	default:
+		return nil, os.NewError(fileName + ": unknown scaling method " + s.Method)
+	}
+	for _, c := range s.Columns {
+		if c.Scale == 0 {
+			return nil, os.NewError(fileName + ": zero scale for column " + c.Name)
+		}
+	}
+	return s, nil
+}
+
+// The scaling given by -scaling, once it has been loaded
+var loadedScaling *scaling
+
+// A scaleReader scales the numeric columns read by another reader. Scaled
+// integer columns become numeric.
+type scaleReader struct {
+	dataReader
+	s   *scaling
+	hdr header
+	// The scaling of each column, or nil for those left as they are
+	columns []*columnScaling
+}
+
+func (r *scaleReader) header() *header {
+	return &r.hdr
+}
+
+func (r *scaleReader) read() (*instance, os.Error) {
+	inst, err := r.dataReader.read()
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range r.columns {
+		if v := &inst.values[i]; c != nil && !v.missing {
+			v.num = r.s.apply(c, v.num)
+			v.str = strconv.Ftoa64(v.num, 'g', -1)
+		}
+	}
+	return inst, nil
+}
+
+// newScaleReader wraps reader so that it scales the columns named by the
+// file given by -scaling, if there is one. Columns the file names which are
+// not read, such as those left out by -drop, are passed over.
+func newScaleReader(reader dataReader) (dataReader, os.Error) {
+	if *scalingFlag == "" {
+		return reader, nil
+	}
+	if loadedScaling == nil {
+		s, err := loadScaling(*scalingFlag)
+		if err != nil {
+			return nil, err
+		}
+		loadedScaling = s
+	}
+	hdr := reader.header()
+	r := &scaleReader{dataReader: reader, s: loadedScaling,
+		hdr: header{name: hdr.name, schema: hdr.schema, whole: hdr.whole,
+			kept: hdr.kept},
+		columns: make([]*columnScaling, hdr.features.Len())}
+	for i := 0; i < hdr.features.Len(); i++ {
+		r.hdr.features.Push(hdr.attr(i))
+	}
+	for j := range loadedScaling.Columns {
+		c := &loadedScaling.Columns[j]
+		i := hdr.attrIndex(c.Name)
+		if i < 0 {
+			debugMsg("Column %s is not read, so is not scaled", c.Name)
+			continue
+		}
+		if !hdr.attr(i).isNumeric() {
+			return nil, os.NewError("cannot scale column " + c.Name +
+				", which is not numeric")
+		}
+		f := *hdr.attr(i)
+		f.datatype = typeNumeric
+		r.hdr.features.Set(i, &f)
+		r.columns[i] = c
+	}
+	return r, nil
+}
+
+// A columnFit gathers what is needed to fit the scaling of a column
+type columnFit struct {
+	f      *feature
+	stats  runningStats
+	sample reservoir
+}
+
+// fitScaling reads the named training set once and fits the scaling given
+// by -scale to each of its numeric columns, other than the class and any
+// identifier, timestamp or weight columns of the schema. The parameters are
+// written to a .scaling.json file, for -scaling to apply to the training set,
+// its test sets and any later data. The median and quartiles of robust
+// scaling are estimated from a sample of -stats-sample values. Scaling is
+// fitted to the data as it is, so -scaling may not be given.
+func fitScaling(fileName string) os.Error {
+	if *scalingFlag != "" {
+		return os.NewError("fit cannot be given -scaling, as it fits the " +
+			"unscaled data")
+	}
+	method := *scaleFlag
+	switch method {
+	case "minmax", "zscore", "robust", "log1p":
+	default:
+		return os.NewError("unknown scaling method " + method)
+	}
+	if strings.Index(fileName, ".train") < 0 {
+		log.Printf("Fitting scaling to %s, which does not look like a "+
+			"training set\n", fileName)
+	}
+	reader, dataFile, err := openDataReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	hdr := reader.header()
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	rng := newSampleRand()
+	s := hdr.schema
+	var fits []*columnFit
+	columns := map[int]*columnFit{}
+	for i := 0; i < hdr.features.Len(); i++ {
+		f := hdr.attr(i)
+		if i == class || !f.isNumeric() {
+			continue
+		}
+		if s != nil {
+			switch s.Columns[i].Role {
+			case roleIdentifier, roleTimestamp, roleWeight:
+				continue
+			}
+		}
+		fit := &columnFit{f: f, sample: reservoir{size: *statsSampleFlag, rng: rng}}
+		fits = append(fits, fit)
+		columns[i] = fit
+	}
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, fit := range columns {
+			if v := inst.values[i]; !v.missing {
+				x := v.num
+				if method == "log1p" {
+					x = log1p(x)
+				}
+				fit.stats.add(x)
+				fit.sample.add(x)
+			}
+		}
+	}
+	params := &scaling{Method: method, Fitted: fileName}
+	for _, fit := range fits {
+		c := columnScaling{Name: fit.f.name, Scale: 1}
+		switch method {
+		case "minmax":
+			c.Offset, c.Scale = fit.stats.min, fit.stats.max-fit.stats.min
+		case "zscore":
+			c.Offset, c.Scale = fit.stats.mean, fit.stats.std()
+		case "robust":
+			q := fit.sample.quantiles([]float64{0.25, 0.5, 0.75})
+			c.Offset, c.Scale = q[1], q[2]-q[0]
+		}
+		// Constant columns are only shifted
+		if c.Scale == 0 {
+			c.Scale = 1
+		}
+		params.Columns = append(params.Columns, c)
+	}
+	text, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	names := newOutputNames(fileName, ".scaling.json")
+	paramsName, err := names.name(".scaling.json")
+	if err != nil {
+		return err
+	}
+	paramsFile, err := createFile(paramsName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(paramsFile)
+	if _, err = paramsFile.Write(append(text, '\n')); err != nil {
+		return err
+	}
+	log.Printf("Fitted %s scaling to %d columns\n", method, len(params.Columns))
+	return nil
+}
+
+// commandFit fits scaling parameters to each of the files
+func commandFit(files []string) {
+	for _, fileName := range files {
+		errCheck(fitScaling(fileName))
+	}
+}
+
+func init() {
+	commands["fit"] = command{
+		"fit the scaling given by -scale to a training set, for -scaling",
+		commandFit}
+}
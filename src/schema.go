@@ -0,0 +1,474 @@
+/* 
+ * schema.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"json"
+	"math"
+	"net"
+	"os"
+	"strings"
+)
+
+var schemaFlag = flag.String("schema", "",
+	"JSON file describing the type and role of each column of the data, "+
+		"the name of a built-in schema such as flow, or none")
+
+// The types a column of a schema may have
+const (
+	columnIP      = "ip"
+	columnPort    = "port"
+	columnInt     = "int"
+	columnFloat   = "float"
+	columnNominal = "nominal"
+	columnLabel   = "label"
+	columnString  = "string"
+	columnDate    = "date"
+)
+
+// The roles a column of a schema may play
+const (
+	roleIdentifier = "identifier"
+	roleFeature    = "feature"
+	roleClass      = "class"
+	roleTimestamp  = "timestamp"
+	roleWeight     = "weight"
+)
+
+// A schemaColumn describes one column of a data set
+type schemaColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Role string `json:"role"`
+	// The values a nominal or label column may take. Any value is allowed
+	// if none are given.
+	Values []string `json:"values,omitempty"`
+	// The format of a date column, as in ARFF
+	Format string `json:"format,omitempty"`
+}
+
+// A schema describes the columns of a data set, so that the data may be
+// checked as it is read and the meaning of each column need not be guessed.
+type schema struct {
+	Relation string         `json:"relation"`
+	Columns  []schemaColumn `json:"columns"`
+	// The header the schema describes
+	hdr header
+}
+
+// loadSchema reads and checks the named schema file
+func loadSchema(fileName string) (*schema, os.Error) {
+	text, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	s := new(schema)
+	if err = json.Unmarshal(text, s); err != nil {
+		return nil, os.NewError(fileName + ": " + err.String())
+	}
+	if err = s.validate(); err != nil {
+		return nil, os.NewError(fileName + ": " + err.String())
+	}
+	return s, nil
+}
+
+// The schema given by -schema, once it has been loaded
+var loadedSchema *schema
+
+// datasetSchema returns the schema given by -schema, or nil if there is none.
+// A schema may be given by the name of a built-in schema, or by a file.
+func datasetSchema() *schema {
+	if loadedSchema == nil && *schemaFlag != "" && *schemaFlag != "none" {
+		if s, exists := builtinSchemas[*schemaFlag]; exists {
+			loadedSchema = s
+			return s
+		}
+		s, err := loadSchema(*schemaFlag)
+		errCheck(err)
+		loadedSchema = s
+	}
+	return loadedSchema
+}
+
+// validate checks that the schema makes sense, filling in the defaults of
+// anything left out, and builds the header it describes.
+func (s *schema) validate() os.Error {
+	if len(s.Columns) == 0 {
+		return os.NewError("the schema has no columns")
+	}
+	seen := map[string]bool{}
+	roles := map[string]int{}
+	for i := range s.Columns {
+		c := &s.Columns[i]
+		if c.Name == "" {
+			return fmt.Errorf("column %d has no name", i)
+		}
+		if seen[c.Name] {
+			return os.NewError("duplicate column " + c.Name)
+		}
+		seen[c.Name] = true
+		if c.Role == "" {
+			c.Role = roleFeature
+			if c.Type == columnLabel {
+				c.Role = roleClass
+			}
+		}
+		switch c.Type {
+		case columnIP, columnPort, columnInt, columnFloat, columnString:
+		case columnNominal, columnLabel:
+		case columnDate:
+			if c.Format == "" {
+				c.Format = defaultDateFormat
+			}
+		default:
+			return os.NewError("unknown type " + c.Type + " for column " + c.Name)
+		}
+		if len(c.Values) > 0 && c.Type != columnNominal && c.Type != columnLabel {
+			return os.NewError("values given for " + c.Type + " column " + c.Name)
+		}
+		switch c.Role {
+		case roleIdentifier, roleFeature, roleClass, roleTimestamp:
+		case roleWeight:
+			if c.Type != columnInt && c.Type != columnFloat {
+				return os.NewError("weight column " + c.Name + " is not numeric")
+			}
+		default:
+			return os.NewError("unknown role " + c.Role + " for column " + c.Name)
+		}
+		roles[c.Role]++
+	}
+	if roles[roleClass] > 1 || roles[roleWeight] > 1 {
+		return os.NewError("a schema may only have one class and one weight column")
+	}
+	s.hdr = header{name: s.Relation, schema: s}
+	for _, c := range s.Columns {
+		s.hdr.features.Push(c.feature())
+	}
+	return nil
+}
+
+// feature returns the ARFF attribute holding the column
+func (c *schemaColumn) feature() *feature {
+	f := &feature{name: c.Name}
+	switch c.Type {
+	case columnPort, columnInt:
+		f.datatype = typeInteger
+	case columnFloat:
+		f.datatype = typeNumeric
+	case columnNominal, columnLabel:
+		f.datatype = typeNominal
+		f.nominal = c.Values
+		if len(c.Values) == 0 {
+			f.datatype = typeString
+		}
+	case columnDate:
+		f.datatype = typeDate
+		f.format = c.Format
+		f.layout = goDateLayout(c.Format)
+	default:
+		f.datatype = typeString
+	}
+	return f
+}
+
+// openColumns returns the indices of the nominal and label columns whose
+// values the schema leaves out
+func (s *schema) openColumns() []int {
+	var open []int
+	for i, c := range s.Columns {
+		if (c.Type == columnNominal || c.Type == columnLabel) && len(c.Values) == 0 {
+			open = append(open, i)
+		}
+	}
+	return open
+}
+
+// index returns the index of the first column with the given role, or -1
+func (s *schema) index(role string) int {
+	for i, c := range s.Columns {
+		if c.Role == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkHeader checks that hdr has the columns described by the schema. A
+// label column may not hold numbers or dates, which no learner takes as class
+// names, and the int, float and port columns must be numeric. A class may be
+// numeric as long as the schema declares it so.
+func (s *schema) checkHeader(hdr *header) os.Error {
+	if hdr.features.Len() != len(s.Columns) {
+		return fmt.Errorf("the data has %d columns but the schema describes %d",
+			hdr.features.Len(), len(s.Columns))
+	}
+	for i, c := range s.Columns {
+		if name := hdr.attr(i).name; name != c.Name {
+			return fmt.Errorf("column %d is named %s in the data but %s in "+
+				"the schema", i, name, c.Name)
+		}
+		f := hdr.attr(i)
+		switch c.Type {
+		case columnLabel:
+			if f.isNumeric() || f.datatype == typeDate {
+				return os.NewError("label column " + c.Name + " is not " +
+					"nominal in the data")
+			}
+		case columnInt, columnFloat, columnPort:
+			if !f.isNumeric() {
+				return os.NewError(c.Type + " column " + c.Name + " is not " +
+					"numeric in the data")
+			}
+		}
+	}
+	return nil
+}
+
+// checkValue checks that v is allowed in column i of the schema
+func (s *schema) checkValue(i int, v value) os.Error {
+	if v.missing {
+		return nil
+	}
+	c := &s.Columns[i]
+	switch c.Type {
+	case columnIP:
+		if net.ParseIP(v.str) == nil {
+			return os.NewError("invalid address " + v.str + " for column " + c.Name)
+		}
+	case columnPort:
+		if v.num < 0 || v.num > 65535 || v.num != math.Floor(v.num) {
+			return os.NewError("invalid port " + v.str + " for column " + c.Name)
+		}
+	case columnInt:
+		if v.num != math.Floor(v.num) {
+			return os.NewError("invalid integer " + v.str + " for column " + c.Name)
+		}
+	case columnNominal, columnLabel:
+		if len(c.Values) == 0 {
+			return nil
+		}
+		for _, allowed := range c.Values {
+			if v.str == allowed {
+				return nil
+			}
+		}
+		return os.NewError("value " + v.str + " is not allowed in column " + c.Name)
+	}
+	return nil
+}
+
+// checkRow checks the fields of a line of CSV data against the schema. The
+// class column is left out of the fields unless labeled is set.
+func (s *schema) checkRow(fields []string, labeled bool) os.Error {
+	class := s.index(roleClass)
+	n := len(s.Columns)
+	if !labeled && class >= 0 {
+		n--
+	}
+	if len(fields) != n {
+		return fmt.Errorf("expected %d values, found %d", n, len(fields))
+	}
+	i := 0
+	for _, field := range fields {
+		if i == class && !labeled {
+			i++
+		}
+		str, quoted, err := unquote(field)
+		if err != nil {
+			return err
+		}
+		v := value{missing: true}
+		if str != "" || quoted {
+			if v, err = s.hdr.attr(i).parseValue(str, quoted); err != nil {
+				return err
+			}
+		}
+		if err = s.checkValue(i, v); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
+
+// A schemaReader checks each instance read by another reader against the
+// schema, and takes the weight of the instance from its weight column.
+type schemaReader struct {
+	dataReader
+	s     *schema
+	count int
+}
+
+func (r *schemaReader) read() (*instance, os.Error) {
+	inst, err := r.dataReader.read()
+	if err != nil {
+		return nil, err
+	}
+	r.count++
+	for i, v := range inst.values {
+		if err = r.s.checkValue(i, v); err != nil {
+			return nil, fmt.Errorf("instance %d: %s", r.count, err)
+		}
+	}
+	if w := r.s.index(roleWeight); w >= 0 && !inst.values[w].missing {
+		inst.weight = inst.values[w].num
+	}
+	return inst, nil
+}
+
+// schemaFromHeader builds a schema describing the columns of hdr, taking the
+// column with index class as the class. A nominal or string class is written
+// as a label column, and any other keeps its type.
+func schemaFromHeader(hdr *header, class int) *schema {
+	s := &schema{Relation: hdr.name}
+	for i := 0; i < hdr.features.Len(); i++ {
+		f := hdr.attr(i)
+		c := schemaColumn{Name: f.name, Role: roleFeature}
+		switch f.datatype {
+		case typeInteger:
+			c.Type = columnInt
+		case typeNumeric, typeReal:
+			c.Type = columnFloat
+		case typeNominal:
+			c.Type, c.Values = columnNominal, f.nominal
+		case typeDate:
+			c.Type, c.Format, c.Role = columnDate, f.format, roleTimestamp
+		default:
+			c.Type = columnString
+		}
+		if i == class {
+			c.Role = roleClass
+			if c.Type == columnNominal || c.Type == columnString {
+				c.Type = columnLabel
+			}
+		}
+		s.Columns = append(s.Columns, c)
+	}
+	return s
+}
+
+// findNetworkColumns reads through the data to find the columns of s which
+// hold addresses and ports, which no header can tell apart from other strings
+// and integers. A string or nominal feature is an address if every value is
+// one, and an integer column is a port if its name says so and every value
+// is in range.
+func (s *schema) findNetworkColumns(reader dataReader) os.Error {
+	ip := make([]bool, len(s.Columns))
+	port := make([]bool, len(s.Columns))
+	seen := make([]bool, len(s.Columns))
+	for i, c := range s.Columns {
+		ip[i] = c.Type == columnString || c.Type == columnNominal
+		port[i] = c.Type == columnInt &&
+			strings.Index(strings.ToLower(c.Name), "port") >= 0
+	}
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range inst.values {
+			if v.missing {
+				continue
+			}
+			seen[i] = true
+			if ip[i] && net.ParseIP(v.str) == nil {
+				ip[i] = false
+			}
+			if port[i] && (v.num < 0 || v.num > 65535) {
+				port[i] = false
+			}
+		}
+	}
+	for i := range s.Columns {
+		c := &s.Columns[i]
+		switch {
+		case ip[i] && seen[i]:
+			c.Type, c.Values = columnIP, nil
+		case port[i]:
+			c.Type = columnPort
+		}
+	}
+	return nil
+}
+
+// writeSchema writes the schema describing the named data file to a
+// .columns.json file, for use with -schema.
+func writeSchema(fileName string) os.Error {
+	reader, dataFile, err := openDataReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	class, err := classIndex(reader.header())
+	if err != nil {
+		return err
+	}
+	s := schemaFromHeader(reader.header(), class)
+	if err = s.findNetworkColumns(reader); err != nil {
+		return err
+	}
+	names := newOutputNames(fileName, ".columns.json")
+	schemaName, err := names.name(".columns.json")
+	if err != nil {
+		return err
+	}
+	return saveSchema(s, schemaName)
+}
+
+// saveSchema writes s to the named file as JSON
+func saveSchema(s *schema, fileName string) os.Error {
+	text, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	schemaFile, err := createFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer closeFile(schemaFile)
+	_, err = schemaFile.Write(append(text, '\n'))
+	return err
+}
+
+// commandSchema writes a schema describing each of the files
+func commandSchema(files []string) {
+	for _, fileName := range files {
+		errCheck(writeSchema(fileName))
+	}
+}
+
+func init() {
+	commands["schema"] = command{"describe the columns of data for -schema",
+		commandSchema}
+}
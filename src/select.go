@@ -0,0 +1,412 @@
+/* 
+ * select.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	keepFlag = flag.String("keep", "",
+		"comma separated list of columns to keep, by name or index, leaving "+
+			"out the rest")
+	dropFlag = flag.String("drop", "",
+		"comma separated list of columns to leave out, by name or index")
+	featuresFlag = flag.String("features", "",
+		"file listing the columns to keep, as written by select")
+	selectCorrelationFlag = flag.Float64("select-correlation", 0.95,
+		"correlation above which select drops the less informative of two "+
+			"numeric columns")
+	selectTopFlag = flag.Int("select-top", 0,
+		"number of columns kept by select, by information gain, or 0 for all")
+)
+
+// newProjection returns the projection of the columns of hdr chosen by
+// -keep, -features and -drop, or nil if none of them is given. The class
+// column, given by its index, is always kept. Pass -1 if there is none.
+func newProjection(hdr *header, class int) (*projection, os.Error) {
+	if *keepFlag == "" && *featuresFlag == "" && *dropFlag == "" {
+		return nil, nil
+	}
+	kept := make([]bool, hdr.features.Len())
+	for i := range kept {
+		kept[i] = *keepFlag == "" && *featuresFlag == ""
+	}
+	keep, err := parseColumnList(*keepFlag, hdr)
+	if err != nil {
+		return nil, err
+	}
+	if *featuresFlag != "" {
+		names, err := readFeaturesFile(*featuresFlag)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			i := hdr.attrIndex(name)
+			if i < 0 {
+				return nil, os.NewError("no such attribute: " + name)
+			}
+			keep = append(keep, i)
+		}
+	}
+	for _, i := range keep {
+		kept[i] = true
+	}
+	drop, err := parseColumnList(*dropFlag, hdr)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range drop {
+		kept[i] = false
+	}
+	if class >= 0 && class < len(kept) {
+		kept[class] = true
+	}
+	p := new(projection)
+	for i, k := range kept {
+		if k {
+			p.keep = append(p.keep, i)
+		}
+	}
+	return p, nil
+}
+
+// fields returns the fields of a line of data which are kept. A line may
+// have fewer fields than the header, as when it is yet to be labeled.
+func (p *projection) fields(fields []string) []string {
+	var kept []string
+	for _, i := range p.keep {
+		if i < len(fields) {
+			kept = append(kept, fields[i])
+		}
+	}
+	return kept
+}
+
+// index returns the index among the columns kept of the column with index i
+// in the data, or -1 if it is not kept
+func (p *projection) index(i int) int {
+	for j, k := range p.keep {
+		if k == i {
+			return j
+		}
+	}
+	return -1
+}
+
+// schema returns the schema describing the columns kept of those described
+// by s
+func (p *projection) schema(s *schema) (*schema, os.Error) {
+	projected := &schema{Relation: s.Relation}
+	for _, i := range p.keep {
+		projected.Columns = append(projected.Columns, s.Columns[i])
+	}
+	if err := projected.validate(); err != nil {
+		return nil, err
+	}
+	return projected, nil
+}
+
+// A projectReader reads the columns of a projection from another reader
+type projectReader struct {
+	dataReader
+	p   *projection
+	hdr header
+}
+
+func (r *projectReader) header() *header {
+	return &r.hdr
+}
+
+func (r *projectReader) read() (*instance, os.Error) {
+	inst, err := r.dataReader.read()
+	if err != nil {
+		return nil, err
+	}
+	return r.p.instance(inst), nil
+}
+
+// newProjectReader wraps reader so that it reads only the columns chosen by
+// -keep, -features and -drop, if any are given. The header read keeps the
+// header of all the columns, so that -class and -exclude may still give
+// columns by their index in the data, along with the part of any schema
+// describing the columns kept.
+func newProjectReader(reader dataReader) (dataReader, os.Error) {
+	hdr := reader.header()
+	class, err := classIndex(hdr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newProjection(hdr, class)
+	if p == nil || err != nil {
+		return reader, err
+	}
+	r := &projectReader{reader, p, p.header(hdr)}
+	r.hdr.whole, r.hdr.kept = hdr, p
+	if hdr.schema != nil {
+		if r.hdr.schema, err = p.schema(hdr.schema); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// lineProjection returns the projection of lines of CSV data, given the
+// fields of the first line and any schema describing them, or nil if no
+// columns are to be left out. Columns are named by the schema, or else
+// numbered.
+func lineProjection(s *schema, fields []string, class int) *projection {
+	hdr := numberedHeader(nil, len(fields))
+	if s != nil {
+		hdr = &s.hdr
+	}
+	p, err := newProjection(hdr, class)
+	errCheck(err)
+	return p
+}
+
+// featureLine returns the line naming a column in a .features file. Names
+// which would not be read back as they are, such as those with spaces around
+// them or starting with #, are quoted as in ARFF.
+func featureLine(name string) string {
+	line := arffQuote(name)
+	if line == name && strings.HasPrefix(name, "#") {
+		return "'" + name + "'"
+	}
+	return line
+}
+
+// readFeaturesFile reads the names of the columns listed in a .features file,
+// one per line. Lines starting with # are comments.
+func readFeaturesFile(fileName string) ([]string, os.Error) {
+	debugMsg("Opening file: %s", fileName)
+	featuresFile, err := openInput(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer featuresFile.Close()
+	featuresReader := bufio.NewReader(featuresFile)
+	var names []string
+	lineCount := 0
+	for {
+		line, err := featuresReader.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			if err != os.EOF {
+				return nil, err
+			}
+			return names, nil
+		}
+		lineCount++
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, err := unquote(line)
+		if err != nil {
+			return nil, &parseError{lineCount, err.String()}
+		}
+		names = append(names, name)
+	}
+	panic("unreachable")
+}
+
+// correlation keeps the Pearson correlation of two series of numbers,
+// updated as each pair is added
+type correlation struct {
+	n, meanX, meanY, m2X, m2Y, cXY float64
+}
+
+func (c *correlation) add(x, y float64) {
+	c.n++
+	dx := x - c.meanX
+	c.meanX += dx / c.n
+	dy := y - c.meanY
+	c.meanY += dy / c.n
+	c.m2X += dx * (x - c.meanX)
+	c.m2Y += dy * (y - c.meanY)
+	c.cXY += dx * (y - c.meanY)
+}
+
+// r returns the correlation, or 0 if either series is constant
+func (c *correlation) r() float64 {
+	if c.m2X <= 0 || c.m2Y <= 0 {
+		return 0
+	}
+	return c.cXY / math.Sqrt(c.m2X*c.m2Y)
+}
+
+// byGain sorts columns from the most to the least informative
+type byGain []columnSeparability
+
+func (s byGain) Len() int           { return len(s) }
+func (s byGain) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byGain) Less(i, j int) bool { return s[i].Gain > s[j].Gain }
+
+// selectFeatures reads the named labeled data file once and chooses the
+// columns worth keeping. Identifier columns of the schema and constant
+// columns are dropped. Then, from the most informative column down, a
+// numeric column is dropped if it is more correlated than
+// -select-correlation with one already kept, and only the first -select-top
+// columns are kept. The columns kept are written to a .features file, which
+// -features applies to other data, such as test sets.
+func selectFeatures(fileName string) os.Error {
+	reader, dataFile, err := openDataReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	hdr := reader.header()
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	b := newLabelBreakdown(hdr, class)
+	var numeric []int
+	for i := 0; i < hdr.features.Len(); i++ {
+		if i != class && hdr.attr(i).isNumeric() {
+			numeric = append(numeric, i)
+		}
+	}
+	// The correlation of each pair of numeric columns, by their indices
+	pairs := map[int]map[int]*correlation{}
+	for _, i := range numeric {
+		pairs[i] = map[int]*correlation{}
+		for _, j := range numeric {
+			if j > i {
+				pairs[i][j] = new(correlation)
+			}
+		}
+	}
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b.add(inst)
+		for i, row := range pairs {
+			for j, c := range row {
+				if !inst.values[i].missing && !inst.values[j].missing {
+					c.add(inst.values[i].num, inst.values[j].num)
+				}
+			}
+		}
+	}
+	reasons := map[string]string{}
+	s := hdr.schema
+	for _, c := range b.columns {
+		switch {
+		case s != nil && s.Columns[hdr.attrIndex(c.f.name)].Role == roleIdentifier:
+			reasons[c.f.name] = "identifier"
+		case c.f.isNumeric() && (c.all.n == 0 || c.all.min == c.all.max):
+			reasons[c.f.name] = "constant"
+		case !c.f.isNumeric() && len(c.values.values) <= 1:
+			reasons[c.f.name] = "constant"
+		}
+	}
+	ranked := b.summaries()
+	sort.Stable(byGain(ranked))
+	var kept []string
+	for _, c := range ranked {
+		if reasons[c.Name] != "" {
+			continue
+		}
+		i := hdr.attrIndex(c.Name)
+		for _, name := range kept {
+			k := hdr.attrIndex(name)
+			lo, hi := i, k
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if pair, exists := pairs[lo][hi]; exists &&
+				math.Fabs(pair.r()) > *selectCorrelationFlag {
+				reasons[c.Name] = fmt.Sprintf("correlated with %s (r=%.3f)",
+					name, pair.r())
+				break
+			}
+		}
+		if reasons[c.Name] != "" {
+			continue
+		}
+		if *selectTopFlag > 0 && len(kept) >= *selectTopFlag {
+			reasons[c.Name] = fmt.Sprintf("not in the top %d by information gain",
+				*selectTopFlag)
+			continue
+		}
+		kept = append(kept, c.Name)
+	}
+	names := newOutputNames(fileName, ".features")
+	featuresName, err := names.name(".features")
+	if err != nil {
+		return err
+	}
+	featuresFile, err := createFile(featuresName)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(featuresFile)
+	defer closeFile(featuresFile)
+	fmt.Fprintf(out, "# Columns of %s chosen by adp select, for -features\n",
+		fileName)
+	for i := 0; i < hdr.features.Len(); i++ {
+		name := hdr.attr(i).name
+		switch {
+		case i == class:
+			fmt.Fprintf(out, "# %s is the class, which is always kept\n", name)
+		case reasons[name] != "":
+			fmt.Fprintf(out, "# dropped %s: %s\n", name, reasons[name])
+		default:
+			fmt.Fprintln(out, featureLine(name))
+		}
+	}
+	log.Printf("Kept %d of %d columns: %s\n", len(kept), len(b.columns),
+		strings.Join(kept, ", "))
+	return out.Flush()
+}
+
+// commandSelect chooses the columns worth keeping in each of the files
+func commandSelect(files []string) {
+	for _, fileName := range files {
+		errCheck(selectFeatures(fileName))
+	}
+}
+
+func init() {
+	commands["select"] = command{
+		"choose the columns of labeled data worth keeping, for -features",
+		commandSelect}
+}
@@ -0,0 +1,404 @@
+/* 
+ * stats.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"json"
+	"log"
+	"math"
+	"os"
+	"rand"
+	"sort"
+	"strings"
+	"tabwriter"
+)
+
+var (
+	statsFormatFlag = flag.String("stats-format", "text",
+		"format statistics are written in: text or json")
+	statsDistinctFlag = flag.Int("stats-distinct", 1000,
+		"most distinct values of a column counted by stats")
+	statsSampleFlag = flag.Int("stats-sample", 10000,
+		"number of values of each numeric column sampled to estimate quantiles")
+)
+
+// The quantiles reported for numeric columns, and the number of most common
+// values reported for every column
+var statsQuantiles = []float64{0.05, 0.25, 0.5, 0.75, 0.95}
+
+const statsTopValues = 5
+
+// A reservoir keeps a uniform random sample of a stream of numbers, from
+// which quantiles may be estimated without storing the whole stream. This is
+// Vitter's algorithm R.
+type reservoir struct {
+	size   int
+	seen   int
+	sample []float64
+	rng    *rand.Rand
+}
+
+// newSampleRand returns the source of the random choices made in sampling.
+// The same seed samples the same values each time the data is read, so that
+// the results can be repeated.
+func newSampleRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+func (r *reservoir) add(x float64) {
+	r.seen++
+	if len(r.sample) < r.size {
+		r.sample = append(r.sample, x)
+	} else if i := r.rng.Intn(r.seen); i < r.size {
+		r.sample[i] = x
+	}
+}
+
+// quantiles returns the given quantiles of the sample, interpolating between
+// the values either side of each
+func (r *reservoir) quantiles(qs []float64) []float64 {
+	sorted := make(sort.Float64Slice, len(r.sample))
+	copy(sorted, r.sample)
+	sorted.Sort()
+	values := make([]float64, len(qs))
+	if len(sorted) == 0 {
+		return values
+	}
+	for j, q := range qs {
+		pos := q * float64(len(sorted)-1)
+		i := int(math.Floor(pos))
+		values[j] = sorted[i]
+		if i+1 < len(sorted) {
+			values[j] += (pos - float64(i)) * (sorted[i+1] - sorted[i])
+		}
+	}
+	return values
+}
+
+// A valueCount is a value and the number of times it was found
+type valueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// byCount sorts values from the most to the least common, and then by value
+type byCount []valueCount
+
+func (s byCount) Len() int      { return len(s) }
+func (s byCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Value < s[j].Value
+}
+
+// sortedCounts returns the values counted in counts, most common first
+func sortedCounts(counts map[string]int) []valueCount {
+	values := make(byCount, 0, len(counts))
+	for v, n := range counts {
+		values = append(values, valueCount{v, n})
+	}
+	sort.Sort(values)
+	return values
+}
+
+// numericStats summarises the values of a numeric column
+type numericStats struct {
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Mean      float64   `json:"mean"`
+	Std       float64   `json:"std"`
+	Quantiles []float64 `json:"quantiles"`
+}
+
+// columnStats summarises a column. When there are more distinct values than
+// -stats-distinct, only those found first are counted, and the count of
+// distinct values and the most common of them are a lower bound.
+type columnStats struct {
+	Name           string        `json:"name"`
+	Type           string        `json:"type"`
+	Count          int           `json:"count"`
+	Missing        int           `json:"missing"`
+	Distinct       int           `json:"distinct"`
+	DistinctCapped bool          `json:"distinct_capped"`
+	Top            []valueCount  `json:"top"`
+	Numeric        *numericStats `json:"numeric,omitempty"`
+}
+
+// datasetStats summarises a data set. The labels are only counted when the
+// class is known, and no more of them than -stats-distinct.
+type datasetStats struct {
+	File         string        `json:"file"`
+	Relation     string        `json:"relation"`
+	Rows         int           `json:"rows"`
+	Class        string        `json:"class,omitempty"`
+	Labels       []valueCount  `json:"labels,omitempty"`
+	LabelsCapped bool          `json:"labels_capped,omitempty"`
+	Quantiles    []float64     `json:"quantiles"`
+	Columns      []columnStats `json:"columns"`
+}
+
+// A columnProfile gathers the statistics of a column as it is read
+type columnProfile struct {
+	f       *feature
+	count   int
+	missing int
+	stats   runningStats
+	sample  reservoir
+	counts  map[string]int
+	capped  bool
+}
+
+func newColumnProfile(f *feature, rng *rand.Rand) *columnProfile {
+	return &columnProfile{f: f, counts: map[string]int{},
+		sample: reservoir{size: *statsSampleFlag, rng: rng}}
+}
+
+func (p *columnProfile) add(v value) {
+	if v.missing {
+		p.missing++
+		return
+	}
+	p.count++
+	if p.f.isNumeric() {
+		p.stats.add(v.num)
+		p.sample.add(v.num)
+	}
+	if _, exists := p.counts[v.str]; exists || len(p.counts) < *statsDistinctFlag {
+		p.counts[v.str]++
+	} else {
+		p.capped = true
+	}
+}
+
+// summary returns the statistics gathered
+func (p *columnProfile) summary() columnStats {
+	c := columnStats{Name: p.f.name, Type: p.f.datatype, Count: p.count,
+		Missing: p.missing, Distinct: len(p.counts), DistinctCapped: p.capped,
+		Top: sortedCounts(p.counts)}
+	if len(c.Top) > statsTopValues {
+		c.Top = c.Top[0:statsTopValues]
+	}
+	if p.f.isNumeric() && p.count > 0 {
+		c.Numeric = &numericStats{p.stats.min, p.stats.max, p.stats.mean,
+			p.stats.std(), p.sample.quantiles(statsQuantiles)}
+	}
+	return c
+}
+
+// givenClass returns the index of the class of hdr if it is given by -class
+// or the schema of hdr, or -1. Unlike classIndex, it does not take the last
+// column to be the class, as the data may not have been labeled yet.
+func givenClass(hdr *header) (int, os.Error) {
+	if flagSet("class") {
+		return classIndex(hdr)
+	}
+	if hdr.schema != nil {
+		return hdr.schema.index(roleClass), nil
+	}
+	return -1, nil
+}
+
+// profile reads the named data file, gathering the statistics of each of its
+// columns and, if the class is given, the number of instances of each label.
+// The statistics are gathered in a single pass over the data, but unless a
+// schema describes every column of a CSV file, the file has already been read
+// through once by openDataReader to find the types of its columns.
+func profile(fileName string) (*datasetStats, os.Error) {
+	reader, dataFile, err := openDataReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+	hdr := reader.header()
+	if !isArff(fileName) && (hdr.schema == nil || len(hdr.schema.openColumns()) > 0) {
+		log.Printf("Read %s through once to find the types of its columns, "+
+			"profiling it takes a second pass (a schema describing every "+
+			"column avoids the first)\n", fileName)
+	}
+	class, err := givenClass(hdr)
+	if err != nil {
+		return nil, err
+	}
+	rng := newSampleRand()
+	profiles := make([]*columnProfile, hdr.features.Len())
+	for i := range profiles {
+		profiles[i] = newColumnProfile(hdr.attr(i), rng)
+	}
+	stats := &datasetStats{File: fileName, Relation: hdr.name,
+		Quantiles: statsQuantiles}
+	if class >= 0 {
+		stats.Class = hdr.attr(class).name
+	}
+	labels := map[string]int{}
+	for {
+		inst, err := reader.read()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats.Rows++
+		for i, v := range inst.values {
+			profiles[i].add(v)
+		}
+		if class < 0 {
+			continue
+		}
+		label := inst.values[class].String()
+		if _, exists := labels[label]; exists || len(labels) < *statsDistinctFlag {
+			labels[label]++
+		} else {
+			stats.LabelsCapped = true
+		}
+	}
+	stats.Labels = sortedCounts(labels)
+	for _, p := range profiles {
+		stats.Columns = append(stats.Columns, p.summary())
+	}
+	return stats, nil
+}
+
+// formatNumber writes x briefly, for a table
+func formatNumber(x float64) string {
+	return fmt.Sprintf("%.6g", x)
+}
+
+// writeText writes the statistics as tables
+func (s *datasetStats) writeText(out *bufio.Writer) os.Error {
+	fmt.Fprintf(out, "%s: relation %s, %d rows\n\n", s.File, s.Relation, s.Rows)
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	heading := []string{"column", "type", "missing", "distinct", "min", "max",
+		"mean", "std"}
+	for _, q := range s.Quantiles {
+		heading = append(heading, formatNumber(q*100)+"%")
+	}
+	fmt.Fprintln(w, strings.Join(append(heading, "top values"), "\t"))
+	for _, c := range s.Columns {
+		distinct := fmt.Sprint(c.Distinct)
+		if c.DistinctCapped {
+			distinct = ">" + distinct
+		}
+		row := []string{c.Name, c.Type, fmt.Sprint(c.Missing), distinct}
+		if c.Numeric != nil {
+			row = append(row, formatNumber(c.Numeric.Min),
+				formatNumber(c.Numeric.Max), formatNumber(c.Numeric.Mean),
+				formatNumber(c.Numeric.Std))
+			for _, q := range c.Numeric.Quantiles {
+				row = append(row, formatNumber(q))
+			}
+		} else {
+			for i := 0; i < 4+len(s.Quantiles); i++ {
+				row = append(row, "")
+			}
+		}
+		var top []string
+		for _, v := range c.Top {
+			top = append(top, fmt.Sprintf("%s (%d)", v.Value, v.Count))
+		}
+		fmt.Fprintln(w, strings.Join(append(row, strings.Join(top, ", ")), "\t"))
+	}
+	if s.Class != "" {
+		fmt.Fprintf(w, "\nlabel (%s)\tcount\tshare\n", s.Class)
+		for _, l := range s.Labels {
+			fmt.Fprintf(w, "%s\t%d\t%.1f%%\n", l.Value, l.Count,
+				100*float64(l.Count)/float64(s.Rows))
+		}
+		if s.LabelsCapped {
+			fmt.Fprintf(w, "(more than %d labels)\n", len(s.Labels))
+		}
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// A textWriter is a summary of data which may be written as text, for
+// people, as well as JSON
+type textWriter interface {
+	writeText(out *bufio.Writer) os.Error
+}
+
+// writeSummaries writes the summary made by summarize of each of the files
+// to standard output, in the format given by -stats-format
+func writeSummaries(files []string,
+	summarize func(fileName string) (textWriter, os.Error)) os.Error {
+	out := bufio.NewWriter(os.Stdout)
+	for _, fileName := range files {
+		summary, err := summarize(fileName)
+		if err != nil {
+			return os.NewError(fileName + ": " + err.String())
+		}
+		switch *statsFormatFlag {
+		case "text":
+			err = summary.writeText(out)
+		case "json":
+			var text []byte
+			if text, err = json.MarshalIndent(summary, "", "  "); err == nil {
+				_, err = out.Write(append(text, '\n'))
+			}
+		default:
+			err = os.NewError("unknown statistics format " + *statsFormatFlag)
+		}
+		if err != nil {
+			return err
+		}
+		if err = out.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStats writes the statistics of each of the files to standard output
+func writeStats(files []string) os.Error {
+	return writeSummaries(files, func(fileName string) (textWriter, os.Error) {
+		return profile(fileName)
+	})
+}
+
+// commandStats shows the statistics of each of the files
+func commandStats(files []string) {
+	errCheck(writeStats(files))
+}
+
+// state 4 - Show the statistics of a data set
+func interactiveStats() {
+	fileName := promptString("filename",
+		"What file would you like the statistics of?")
+	errCheck(writeStats([]string{fileName}))
+}
+
+func init() {
+	commands["stats"] = command{"show statistics of each column of data",
+		commandStats}
+}
@@ -29,33 +29,114 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"rand"
 	"sort"
+	"strconv"
 	"strings"
 )
 
+var trainFlag = flag.String("train", "",
+	"comma separated label=count pairs giving the number of instances of "+
+		"each label to put in the training set")
+
 // state 2 - Build and train test set
 func interactiveBuildTrainAndTestSet() {
-	var (
-		err         os.Error
-		inputString string
-		inputInt    int
-	)
 	// STEP 1:
 	// Begin building training and test set
 	fmt.Println("Building train and test set")
-	inputString = promptString("filename", "What file would you like to split?")
-	debugMsg("Opening file: %s", inputString)
+	inputString := promptString("filename", "What file would you like to split?")
+	splitDataSet(inputString, promptTrainCounts)
+	fmt.Println()
+}
+
+// commandSplit splits each of the files, putting the number of each label
+// given by -train in the training set
+func commandSplit(files []string) {
+	for _, fileName := range files {
+		splitDataSet(fileName, flagTrainCounts)
+	}
+}
+
+// promptTrainCounts asks the user how many of each label, of those counted
+// in countMap, they'd like in the training set
+func promptTrainCounts(countMap map[string]int) map[string]int {
+	// Hold the amount of each label we'd like in the training set in a map
+	trainCountMap := map[string]int{}
+	fmt.Println("Please enter the number of each type of label you'd",
+		"like in the training set.")
+	// Ask user how much of each label they want and put it in a map
+	// trainCountMap
+	for k, v := range countMap {
+		trainCountMap[k] = promptInt(k, "label: %s max: %d", k, v)
+	}
+	return trainCountMap
+}
+
+// flagTrainCounts returns how many of each label, of those counted in
+// countMap, were asked for in the training set by -train. Labels which were
+// not asked for are all put in the test sets.
+func flagTrainCounts(countMap map[string]int) map[string]int {
+	wanted := map[string]int{}
+	for _, pair := range strings.Split(*trainFlag, ",") {
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			log.Fatalln("Error: expected label=count in -train, found", pair)
+		}
+		count, err := strconv.Atoi(pair[i+1:])
+		errCheck(err)
+		if _, exists := countMap[pair[0:i]]; !exists {
+			log.Printf("No instances are labeled %s\n", pair[0:i])
+		}
+		wanted[pair[0:i]] = count
+	}
+	trainCountMap := map[string]int{}
+	for k := range countMap {
+		trainCountMap[k] = wanted[k]
+	}
+	return trainCountMap
+}
+
+// splitDataSet splits the labeled data in the named file into a training
+// set, and a test set for each label. Once the labels have been counted,
+// trainCounts decides how many of each to put in the training set. When
+// reading standard input the training set is written to standard output.
+// When -schema is given, or the data matches a built-in schema, each line is
+// checked against it and the label is taken from the column given by -class,
+// or else its class column. Only the columns chosen by -keep, -features and
+// -drop are written.
+func splitDataSet(fileName string,
+	trainCounts func(countMap map[string]int) map[string]int) {
+	var err os.Error
+	debugMsg("Opening file: %s", fileName)
 	// Open the file for reading
-	dataFile, err := os.Open(inputString)
+	dataFile, err := openInput(fileName)
 	errCheck(err)
 	// We do not need this file after, so close it upon leaving this method
 	defer dataFile.Close()
+	names := newOutputNames(fileName, ".train")
+	trainName, err := names.name(".train")
+	errCheck(err)
+	// Each label has a test file, so check those can be named before reading
+	_, err = names.name(".test")
+	errCheck(err)
 	// Create a buffered reader for the file
 	dataReader := bufio.NewReader(dataFile)
 	var line string
+	// The label is the last value of each line, unless a schema says
+	// otherwise
+	var s *schema
+	class := -1
+	// The columns written, if only some of them are
+	var p *projection
+	lineCount := 0
 
 	// STEP 2:
 	// Create a map for storing the temporary files
@@ -69,9 +150,32 @@ func interactiveBuildTrainAndTestSet() {
 	err == nil;                                  // stop on error
 	line, err = dataReader.ReadString('\n') {
 		// Take each instance and write it to a label specific file
+		lineCount++
 		line = strings.Trim(line, "\n")
 		feature := strings.Split(line, ",")
+		if lineCount == 1 {
+			if s = detectSchema(feature, false); s != nil {
+				class, err = classIndex(&s.hdr)
+				errCheck(err)
+			}
+			if class >= 0 {
+				p = lineProjection(s, feature, class)
+			} else {
+				p = lineProjection(s, feature, len(feature)-1)
+			}
+		}
+		if s != nil {
+			if err = s.checkRow(feature, true); err != nil {
+				errCheck(&parseError{lineCount, err.String()})
+			}
+		}
 		label := feature[len(feature)-1]
+		if class >= 0 {
+			label = feature[class]
+		}
+		if p != nil {
+			line = strings.Join(p.fields(feature), ",")
+		}
 		tempFile, exists = tempFileMap[label]
 		countMap[label]++
 		if exists {
@@ -79,16 +183,15 @@ func interactiveBuildTrainAndTestSet() {
 			_, err = tempFile.WriteString(line + "\n")
 			errCheck(err)
 		} else {
-			// Create the file and write the line
-			tempFileName := dataFile.Name() + "." + label + ".tmp"
-			debugMsg("Creating temporary file: %s", tempFileName)
-			tempFile, err := os.OpenFile(
-				tempFileName,
-				os.O_CREATE+os.O_WRONLY+os.O_TRUNC,
-				0666)
+			// Create the file and write the line. Temporary files are kept
+			// out of the output directory, and out of the way of labels
+			// which do not make valid file names.
+			tempFile, err := ioutil.TempFile("", "adp")
+			errCheck(err)
+			debugMsg("Created temporary file: %s", tempFile.Name())
 			tempFileMap[label] = tempFile
 			defer tempFile.Close()
-			defer os.Remove(tempFileName)
+			defer os.Remove(tempFile.Name())
 			_, err = tempFile.WriteString(line + "\n")
 			errCheck(err)
 		}
@@ -108,22 +211,9 @@ func interactiveBuildTrainAndTestSet() {
 	// Receive the number of each label (class) we'd like to add to the training
 	// set
 
-	// Hold the amount of each label we'd like in the training set in a map
-	trainCountMap := map[string]int{}
-	fmt.Println("Please enter the number of each type of label you'd",
-		"like in the training set.")
-	// Ask user how much of each label they want and put it in a map 
-	// trainCountMap
-	for k, v := range countMap {
-		inputInt = promptInt(k, "label: %s max: %d", k, v)
-		trainCountMap[k] = inputInt
-	}
-	debugMsg("Creating: %s", dataFile.Name()+".train")
+	trainCountMap := trainCounts(countMap)
 	// Open a file for writing training data
-	trainFile, err := os.OpenFile(
-		dataFile.Name()+".train",
-		os.O_CREATE+os.O_WRONLY+os.O_TRUNC,
-		0666)
+	trainFile, err := createOutput(trainName)
 	errCheck(err)
 	// We do not need this file after, so close it upon leaving this method
 	defer trainFile.Close()
@@ -132,12 +222,15 @@ func interactiveBuildTrainAndTestSet() {
 
 	for k, v := range trainCountMap {
 		debugMsg("label: %s count: %d", k, v)
+		if v > countMap[k] {
+			log.Printf("Only %d instances are labeled %s\n", countMap[k], k)
+			v = countMap[k]
+		}
 		dataReader := bufio.NewReader(tempFileMap[k])
 		// Open a file for writing testing data
-		testFile, err := os.OpenFile(
-			dataFile.Name()+"."+k+".test",
-			os.O_CREATE+os.O_WRONLY+os.O_TRUNC,
-			0666)
+		testName, err := names.name("." + fileLabel(k) + ".test")
+		errCheck(err)
+		testFile, err := createOutput(testName)
 		errCheck(err)
 		// We do not need this file after, so close it upon leaving this method
 		defer testFile.Close()
@@ -185,7 +278,22 @@ func interactiveBuildTrainAndTestSet() {
 				errCheck(err)
 			}
 		}
-		testFile.Close()
+		errCheck(testFile.Close())
 	}
-	fmt.Println()
+	errCheck(trainFile.Close())
+}
+
+// fileLabel returns label in a form which may be used as part of a file name,
+// with path separators and control characters replaced by underscores.
+func fileLabel(label string) string {
+	b := []byte(label)
+	for i, c := range b {
+		if c == '/' || c == '\\' || c < ' ' {
+			b[i] = '_'
+		}
+	}
+	if label == "" || label == "." || label == ".." {
+		return "_" + string(b)
+	}
+	return string(b)
 }
@@ -0,0 +1,170 @@
+/* 
+ * xrff.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// xmlEscape escapes the characters of s which may not appear as they are in
+// XML text or attribute values.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '"':
+			buf.WriteString("&quot;")
+		case '\'':
+			buf.WriteString("&apos;")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// writeXrffHeader writes the start of an XRFF document, up to the opening of
+// the instances. The feature with index class is marked as the class.
+func writeXrffHeader(hdr *header, class int, out *bufio.Writer) os.Error {
+	out.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(out, "<dataset name=\"%s\" version=\"3.5.3\">\n",
+		xmlEscape(hdr.name))
+	out.WriteString("  <header>\n    <attributes>\n")
+	for i := 0; i < hdr.features.Len(); i++ {
+		f := hdr.attr(i)
+		out.WriteString("      <attribute ")
+		if i == class {
+			out.WriteString("class=\"yes\" ")
+		}
+		datatype := f.datatype
+		if f.isNumeric() {
+			datatype = typeNumeric
+		}
+		fmt.Fprintf(out, "name=\"%s\" type=\"%s\"", xmlEscape(f.name), datatype)
+		switch f.datatype {
+		case typeDate:
+			fmt.Fprintf(out, " format=\"%s\"/>\n", xmlEscape(f.format))
+		case typeNominal:
+			out.WriteString(">\n        <labels>\n")
+			for _, v := range f.nominal {
+				fmt.Fprintf(out, "          <label>%s</label>\n", xmlEscape(v))
+			}
+			out.WriteString("        </labels>\n      </attribute>\n")
+		default:
+			out.WriteString("/>\n")
+		}
+	}
+	_, err := out.WriteString("    </attributes>\n  </header>\n" +
+		"  <body>\n    <instances>\n")
+	return err
+}
+
+// writeXrffInstance writes the given instance as an XRFF instance, including
+// its weight if it is not the default of one.
+func writeXrffInstance(inst *instance, out *bufio.Writer) os.Error {
+	if inst.weight != 1 {
+		fmt.Fprintf(out, "      <instance weight=\"%s\">\n",
+			strconv.Ftoa64(inst.weight, 'g', -1))
+	} else {
+		out.WriteString("      <instance>\n")
+	}
+	for _, v := range inst.values {
+		fmt.Fprintf(out, "        <value>%s</value>\n", xmlEscape(v.String()))
+	}
+	_, err := out.WriteString("      </instance>\n")
+	return err
+}
+
+// xrffConverter writes the data set as Weka's XML based XRFF format, which
+// unlike ARFF marks the class attribute and carries instance weights as
+// attributes of each instance.
+type xrffConverter struct {
+	out *output
+	// The columns written, if any are excluded
+	p *projection
+}
+
+func (c *xrffConverter) open(hdr *header, names *outputNames) os.Error {
+	class, err := classIndex(hdr)
+	if err != nil {
+		return err
+	}
+	name, err := names.name(".xrff")
+	if err != nil {
+		return err
+	}
+	if c.p, err = exclusion(hdr); err != nil {
+		return err
+	}
+	if c.out, err = createOutput(name); err != nil {
+		return err
+	}
+	if c.p != nil {
+		projected := c.p.header(hdr)
+		for j, i := range c.p.keep {
+			if i == class {
+				class = j
+			}
+		}
+		return writeXrffHeader(&projected, class, c.out.Writer)
+	}
+	return writeXrffHeader(hdr, class, c.out.Writer)
+}
+
+func (c *xrffConverter) write(inst *instance) os.Error {
+	if c.p != nil {
+		inst = c.p.instance(inst)
+	}
+	return writeXrffInstance(inst, c.out.Writer)
+}
+
+func (c *xrffConverter) close() os.Error {
+	c.out.WriteString("    </instances>\n  </body>\n</dataset>\n")
+	return c.out.Close()
+}
+
+func init() {
+	registerFormat(&format{
+		name:   "xrff",
+		desc:   "Weka XRFF (.xrff)",
+		suffix: ".xrff",
+		newConverter: func() converter {
+			return new(xrffConverter)
+		},
+	})
+}
@@ -0,0 +1,389 @@
+/* 
+ * zeek.go
+ * 
+ * Copyright (C) 2010 Daniel Arndt
+ * 
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * For more information please visit my website at:
+ * http://web.cs.dal.ca/~darndt
+ *
+ * Or the code's repository:
+ *
+ * http://github.com/danielarndt/adp
+ *  
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A logReader reads the records of a connection log, such as those of Zeek or
+// Argus, as the values of the columns of a schema.
+type logReader interface {
+	// next returns the values of the next record, with ? for those which are
+	// missing, or os.EOF once there are no more.
+	next() ([]string, os.Error)
+	// columns describes the values of the records. It is only known once the
+	// first record has been read.
+	columns() []schemaColumn
+}
+
+// The fields of a Zeek conn.log, in the order Zeek writes them, and their Zeek
+// types. JSON logs carry no types, and leave out the fields which are unset,
+// so these are used for them.
+var zeekConnFields = []string{"ts", "uid", "id.orig_h", "id.orig_p",
+	"id.resp_h", "id.resp_p", "proto", "service", "duration", "orig_bytes",
+	"resp_bytes", "conn_state", "local_orig", "local_resp", "missed_bytes",
+	"history", "orig_pkts", "orig_ip_bytes", "resp_pkts", "resp_ip_bytes",
+	"tunnel_parents"}
+
+var zeekConnTypes = map[string]string{
+	"ts": "time", "uid": "string", "id.orig_h": "addr", "id.orig_p": "port",
+	"id.resp_h": "addr", "id.resp_p": "port", "proto": "enum",
+	"service": "string", "duration": "interval", "orig_bytes": "count",
+	"resp_bytes": "count", "conn_state": "string", "local_orig": "bool",
+	"local_resp": "bool", "missed_bytes": "count", "history": "string",
+	"orig_pkts": "count", "orig_ip_bytes": "count", "resp_pkts": "count",
+	"resp_ip_bytes": "count", "tunnel_parents": "set[string]",
+}
+
+// zeekColumn returns the schema column holding a Zeek field of the given Zeek
+// type. Times are kept as seconds since the epoch. The unique id of each
+// connection and its addresses identify it, and are not features.
+func zeekColumn(name, zeekType string) schemaColumn {
+	c := schemaColumn{Name: name, Role: roleFeature}
+	switch zeekType {
+	case "time":
+		c.Type, c.Role = columnFloat, roleTimestamp
+	case "interval", "double":
+		c.Type = columnFloat
+	case "count", "int":
+		c.Type = columnInt
+	case "port":
+		c.Type = columnPort
+	case "addr":
+		c.Type, c.Role = columnIP, roleIdentifier
+	case "bool", "enum", "string":
+		c.Type = columnNominal
+	default:
+		c.Type = columnString
+	}
+	if name == "uid" {
+		c.Type, c.Role = columnString, roleIdentifier
+	}
+	return c
+}
+
+// A zeekReader reads a Zeek log written as tab separated values, with the
+// fields and their types given by #fields and #types lines, or as JSON with a
+// record on each line.
+type zeekReader struct {
+	in   *bufio.Reader
+	line int // The number of the line last read
+	cols []schemaColumn
+	// The layout of the TSV log, from its # lines
+	separator, setSeparator, emptyField, unsetField string
+	fields, types                                   []string
+	json                                            bool
+}
+
+func newZeekReader(in *bufio.Reader) *zeekReader {
+	r := &zeekReader{in: in, separator: "\t", setSeparator: ",",
+		emptyField: "(empty)", unsetField: "-"}
+	start, _ := in.Peek(1)
+	r.json = len(start) > 0 && start[0] == '{'
+	return r
+}
+
+func (r *zeekReader) columns() []schemaColumn {
+	return r.cols
+}
+
+func (r *zeekReader) next() ([]string, os.Error) {
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && (err != os.EOF || line == "") {
+			return nil, err
+		}
+		r.line++
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		var values []string
+		switch {
+		case r.json:
+			values, err = r.parseJson(line)
+		case strings.HasPrefix(line, "#"):
+			err = r.parseDirective(line)
+		default:
+			values, err = r.parseTsv(line)
+		}
+		if err != nil {
+			return nil, &parseError{r.line, err.String()}
+		}
+		if values != nil {
+			return values, nil
+		}
+	}
+	panic("unreachable")
+}
+
+// parseDirective reads one of the # lines describing the layout of a TSV log.
+// The header is repeated when logs are joined together, but the fields must
+// stay the same.
+func (r *zeekReader) parseDirective(line string) os.Error {
+	if strings.HasPrefix(line, "#separator ") {
+		sep := strings.TrimSpace(line[len("#separator "):])
+		if strings.HasPrefix(sep, "\\x") {
+			n, err := strconv.Btoui64(sep[2:], 16)
+			if err != nil {
+				return os.NewError("bad separator " + sep)
+			}
+			sep = string([]byte{byte(n)})
+		}
+		r.separator = sep
+		return nil
+	}
+	fields := strings.Split(line, r.separator)
+	switch fields[0] {
+	case "#set_separator":
+		r.setSeparator = strings.Join(fields[1:], r.separator)
+	case "#empty_field":
+		r.emptyField = strings.Join(fields[1:], r.separator)
+	case "#unset_field":
+		r.unsetField = strings.Join(fields[1:], r.separator)
+	case "#fields":
+		if r.fields != nil && strings.Join(r.fields, " ") != strings.Join(fields[1:], " ") {
+			return os.NewError("the fields of the log change part way through")
+		}
+		r.fields = fields[1:]
+	case "#types":
+		r.types = fields[1:]
+	}
+	return nil
+}
+
+func (r *zeekReader) parseTsv(line string) ([]string, os.Error) {
+	if r.fields == nil {
+		return nil, os.NewError("no #fields line before the first record")
+	}
+	if r.cols == nil {
+		for i, name := range r.fields {
+			zeekType := zeekConnTypes[name]
+			if i < len(r.types) {
+				zeekType = r.types[i]
+			}
+			r.cols = append(r.cols, zeekColumn(name, zeekType))
+		}
+	}
+	values := strings.Split(line, r.separator)
+	if len(values) != len(r.fields) {
+		return nil, os.NewError(strconv.Itoa(len(r.fields)) + " fields expected, " +
+			strconv.Itoa(len(values)) + " found")
+	}
+	for i, v := range values {
+		switch v {
+		case r.unsetField:
+			values[i] = "?"
+		case r.emptyField:
+			values[i] = ""
+		default:
+			if r.setSeparator != "," {
+				values[i] = strings.Replace(v, r.setSeparator, ",", -1)
+			}
+		}
+	}
+	return values, nil
+}
+
+// parseJson reads a record of a JSON log. The columns are the fields of
+// conn.log followed by any others found in the first record, as JSON objects
+// have no order. Fields found later are left out.
+func (r *zeekReader) parseJson(line string) ([]string, os.Error) {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return nil, err
+	}
+	if r.cols == nil {
+		var extra []string
+		for name := range record {
+			if _, known := zeekConnTypes[name]; !known {
+				extra = append(extra, name)
+			}
+		}
+		sort.Strings(extra)
+		for _, name := range zeekConnFields {
+			r.cols = append(r.cols, zeekColumn(name, zeekConnTypes[name]))
+		}
+		for _, name := range extra {
+			r.cols = append(r.cols, zeekColumn(name, jsonZeekType(record[name])))
+		}
+	}
+	values := make([]string, len(r.cols))
+	for i, c := range r.cols {
+		values[i] = jsonValue(record[c.Name])
+	}
+	return values, nil
+}
+
+// jsonZeekType returns the Zeek type most like that of a JSON value
+func jsonZeekType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "double"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	}
+	return "vector"
+}
+
+// jsonValue returns a JSON value written as it would be in a TSV log, with ?
+// for a value which is missing.
+func jsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.Ftoa64(v, 'f', -1)
+	case bool:
+		if v {
+			return "T"
+		}
+		return "F"
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = jsonValue(v[i])
+		}
+		return strings.Join(values, ",")
+	}
+	return "?"
+}
+
+// csvValue returns v written so it may be read back from a CSV file
+func csvValue(v string) string {
+	if strings.IndexAny(v, ",'\"") >= 0 || v != strings.TrimSpace(v) {
+		return arffQuote(v)
+	}
+	return v
+}
+
+// importLog writes the records of the named log to a CSV file, and a schema
+// describing them to a .columns.json file. The schema ends with a label
+// column, so that it describes the CSV file once labeled as well, and names
+// the columns so that rules may refer to them by name. Each record is checked
+// against the schema, so that the CSV file is known to fit it.
+func importLog(fileName string, open func(in *bufio.Reader) logReader) os.Error {
+	debugMsg("Opening file: %s", fileName)
+	in, err := openInput(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	names := newOutputNames(fileName, ".csv")
+	csvName, err := names.name(".csv")
+	if err != nil {
+		return err
+	}
+	schemaName, err := names.name(".columns.json")
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(csvName)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	reader := open(bufio.NewReader(in))
+	var s *schema
+	count := 0
+	for {
+		values, err := reader.next()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return os.NewError(fileName + ": " + err.String())
+		}
+		// The columns are known once the first record has been read
+		if s == nil {
+			if s, err = logSchema(reader.columns()); err != nil {
+				return os.NewError(fileName + ": " + err.String())
+			}
+		}
+		count++
+		for i := range values {
+			values[i] = csvValue(values[i])
+		}
+		if err = s.checkRow(values, false); err != nil {
+			return fmt.Errorf("%s: record %d: %s", fileName, count, err)
+		}
+		if _, err = out.WriteString(strings.Join(values, ",") + "\n"); err != nil {
+			return err
+		}
+	}
+	if count == 0 {
+		return os.NewError(fileName + " holds no records")
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	log.Printf("Read %d records, label them with -schema %s\n", count, schemaName)
+	return saveSchema(s, schemaName)
+}
+
+// logSchema returns the schema of a log with the given columns, followed by
+// a label column
+func logSchema(columns []schemaColumn) (*schema, os.Error) {
+	s := &schema{Columns: columns}
+	class := schemaColumn{Name: "label", Type: columnLabel, Role: roleClass}
+	for i := 0; i < len(s.Columns); i++ {
+		if s.Columns[i].Name == class.Name {
+			class.Name = "_" + class.Name
+			i = -1
+		}
+	}
+	s.Columns = append(s.Columns, class)
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// commandZeek reads each of the Zeek logs
+func commandZeek(files []string) {
+	for _, fileName := range files {
+		errCheck(importLog(fileName, func(in *bufio.Reader) logReader {
+			return newZeekReader(in)
+		}))
+	}
+}
+
+func init() {
+	commands["zeek"] = command{"read Zeek conn.log files, as TSV or JSON",
+		commandZeek}
+}